@@ -9,13 +9,7 @@ func (c *AbstractCell) Get(x, y int) bool {
 }
 
 func abpix(source, mask int32) bool {
-	switch source & mask {
-	case 0:
-		return false
-	case mask:
-		return true
-	}
-	panic("bad abstract pixel")
+	return source&mask != 0
 }
 
 func PaintAbCell(hextop, hexmid, hexbot int32) AbstractCell {
@@ -11,19 +11,27 @@ func EmptyAbstractionGrid(w, h int) *AbstractionGrid {
 func NewAbstractionGrid(t *TextGrid, cells *CellSet) *AbstractionGrid {
 	g := EmptyAbstractionGrid(3*t.Width(), 3*t.Height())
 	for c := range cells.Set {
-		if t.IsBlank(c) {
-			continue
-		}
-		for _, x := range abstractionChecks {
-			if x.check(t, c) {
-				g.Set(c, x.result)
-				break
-			}
+		if brush, ok := abstractCellFor(t, c); ok {
+			g.Set(c, brush)
 		}
 	}
 	return g
 }
 
+// abstractCellFor returns the abstraction of the cell of t, if it is a
+// part of a boundary.
+func abstractCellFor(t *TextGrid, c Cell) (AbstractCell, bool) {
+	if t.IsBlank(c) {
+		return AbstractCell{}, false
+	}
+	for _, x := range abstractionChecks {
+		if x.check(t, c) {
+			return x.result, true
+		}
+	}
+	return AbstractCell{}, false
+}
+
 func (g *AbstractionGrid) Set(c Cell, brush AbstractCell) {
 	x, y := 3*c.X, 3*c.Y
 	for dy := 0; dy < 3; dy++ {
@@ -0,0 +1,191 @@
+package main
+
+// CellBitmap is a set of the cells of a w×h area, kept as one bit per
+// cell. Unlike CellSet, it is cheap to create and query for dense sets
+// covering a whole grid.
+type CellBitmap struct {
+	W, H int
+	bits []uint64
+}
+
+func NewCellBitmap(w, h int) *CellBitmap {
+	return &CellBitmap{W: w, H: h, bits: make([]uint64, (w*h+63)/64)}
+}
+
+func (b *CellBitmap) isInside(x, y int) bool {
+	return x >= 0 && y >= 0 && x < b.W && y < b.H
+}
+
+// Contains returns false for cells outside the area.
+func (b *CellBitmap) Contains(c Cell) bool {
+	return b.Has(c.X, c.Y)
+}
+
+func (b *CellBitmap) Has(x, y int) bool {
+	if !b.isInside(x, y) {
+		return false
+	}
+	i := y*b.W + x
+	return b.bits[i/64]&(1<<uint(i%64)) != 0
+}
+
+// Add does nothing for cells outside the area.
+func (b *CellBitmap) Add(c Cell) {
+	if !b.isInside(c.X, c.Y) {
+		return
+	}
+	i := c.Y*b.W + c.X
+	b.bits[i/64] |= 1 << uint(i%64)
+}
+
+func (b *CellBitmap) CellSet() *CellSet {
+	s := NewCellSet()
+	for y := 0; y < b.H; y++ {
+		for x := 0; x < b.W; x++ {
+			if b.Has(x, y) {
+				s.Add(Cell{x, y})
+			}
+		}
+	}
+	return s
+}
+
+// abstractionBitmap returns the cells of the abstraction grid of t (see
+// NewAbstractionGrid) which are set for the cells.
+func abstractionBitmap(t *TextGrid, cells *CellSet) *CellBitmap {
+	b := NewCellBitmap(3*t.Width(), 3*t.Height())
+	for c := range cells.Set {
+		brush, ok := abstractCellFor(t, c)
+		if !ok {
+			continue
+		}
+		for dy := 0; dy < 3; dy++ {
+			for dx := 0; dx < 3; dx++ {
+				if brush.Get(dx, dy) {
+					b.Add(Cell{3*c.X + dx, 3*c.Y + dy})
+				}
+			}
+		}
+	}
+	return b
+}
+
+/*
+findBoundarySets returns the boundaries around the blank areas of the
+abstraction of the cells, scaled back to the cells of t.
+
+Every blank area of the abstraction is labelled with a single flood fill,
+in the order of its top-left cell. The areas touching the right or bottom
+edge are given one label, because NewAbstractionGrid pads the abstraction
+with blank space there, which joins them. The boundary of an area is made
+of the set cells next to it.
+*/
+func findBoundarySets(in *interpreter, t *TextGrid, cells *CellSet) []*CellSet {
+	ab := abstractionBitmap(t, cells)
+	w, h := ab.W, ab.H
+	onEdge := func(x, y int) bool { return x == w-1 || y == h-1 }
+
+	// labels[i] is the number of the area of the i-th cell, counted from
+	// 1, or 0 if the cell isn't labelled yet
+	labels := make([]int32, w*h)
+	edgeLabel := int32(0)
+	n := int32(0)
+	stack := []int{}
+	push := func(x, y int, label int32) {
+		i := y*w + x
+		if ab.isInside(x, y) && !ab.Has(x, y) && labels[i] == 0 {
+			labels[i] = label
+			stack = append(stack, i)
+		}
+	}
+	for yi := 0; yi < h; yi++ {
+		in.checkContext()
+		for xi := 0; xi < w; xi++ {
+			if ab.Has(xi, yi) || labels[yi*w+xi] != 0 {
+				continue
+			}
+			n++
+			push(xi, yi, n)
+			for len(stack) > 0 {
+				i := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				x, y := i%w, i/w
+				push(x, y-1, n)
+				push(x, y+1, n)
+				push(x+1, y, n)
+				push(x-1, y, n)
+				if onEdge(x, y) && edgeLabel == 0 {
+					edgeLabel = n
+					for x := 0; x < w; x++ {
+						push(x, h-1, n)
+					}
+					for y := 0; y < h; y++ {
+						push(w-1, y, n)
+					}
+				}
+			}
+		}
+	}
+
+	boundaries := make([][]int, n+1)
+	add := func(label int32, i int) {
+		if k := len(boundaries[label]); k == 0 || boundaries[label][k-1] != i {
+			boundaries[label] = append(boundaries[label], i)
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !ab.Has(x, y) {
+				continue
+			}
+			i := y*w + x
+			for _, c := range []Cell{{x, y - 1}, {x, y + 1}, {x + 1, y}, {x - 1, y}} {
+				if ab.isInside(c.X, c.Y) && !ab.Has(c.X, c.Y) {
+					add(labels[c.Y*w+c.X], i)
+				}
+			}
+			if onEdge(x, y) && edgeLabel != 0 {
+				add(edgeLabel, i)
+			}
+		}
+	}
+
+	result := []*CellSet{}
+	for _, boundary := range boundaries[1:] {
+		// a blank area is next to a set cell unless it is the whole
+		// abstraction, i.e. none of the cells has an abstraction; the
+		// shapes of getDistinctShapes are made of abstraction pixels, so
+		// this only happens for cells from elsewhere, which have no
+		// boundaries to report
+		if len(boundary) == 0 {
+			continue
+		}
+		result = append(result, scaleOneThird(boundary, w))
+	}
+	return result
+}
+
+// scaleOneThird is makeScaledOneThirdEquivalent for the cells with the
+// indices in a grid of width w.
+func scaleOneThird(indices []int, w int) *CellSet {
+	max := Cell{}
+	for _, i := range indices {
+		if x := i % w; x > max.X {
+			max.X = x
+		}
+		if y := i / w; y > max.Y {
+			max.Y = y
+		}
+	}
+	// like makeScaledOneThirdEquivalent, drop the cells outside its
+	// smaller grid
+	limit := Cell{(max.X + 2) / 3, (max.Y + 2) / 3}
+	s := NewCellSet()
+	for _, i := range indices {
+		c := Cell{i % w / 3, i / w / 3}
+		if c.X < limit.X && c.Y < limit.Y {
+			s.Add(c)
+		}
+	}
+	return s
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCellBitmap(t *testing.T) {
+	b := NewCellBitmap(70, 3)
+	cells := []Cell{{0, 0}, {63, 0}, {64, 0}, {69, 2}, {5, 1}}
+	for _, c := range cells {
+		b.Add(c)
+	}
+	b.Add(Cell{70, 0})
+	b.Add(Cell{-1, 1})
+	for _, c := range cells {
+		if !b.Contains(c) {
+			t.Errorf("bitmap doesn't contain %v", c)
+		}
+	}
+	for _, c := range []Cell{{1, 0}, {65, 0}, {70, 0}, {-1, 1}, {0, 3}} {
+		if b.Contains(c) {
+			t.Errorf("bitmap contains %v", c)
+		}
+	}
+	want := NewCellSet()
+	for _, c := range cells {
+		want.Add(c)
+	}
+	checkCellSet(t, b.CellSet(), want)
+}
+
+// boundarySetsByFilling finds the same boundaries as findBoundarySets,
+// the way the original ditaa does: by a separate flood fill of a fresh
+// abstraction grid from every cell not filled yet.
+func boundarySetsByFilling(grid *TextGrid, cells *CellSet) []*CellSet {
+	w, h := grid.Width(), grid.Height()
+	result := []*CellSet{}
+	fillBuffer := NewTextGrid(3*w, 3*h)
+	for yi := 0; yi < 3*h; yi++ {
+		for xi := 0; xi < 3*w; xi++ {
+			if !fillBuffer.IsBlankXY(xi, yi) {
+				continue
+			}
+			copyGrid := &TextGrid{Rows: NewAbstractionGrid(grid, cells).Rows}
+			boundaries := findBoundariesExpandingFrom(copyGrid, Cell{xi, yi})
+			if len(boundaries.Set) == 0 {
+				continue
+			}
+			result = append(result, makeScaledOneThirdEquivalent(boundaries))
+			copyGrid.Rows = NewAbstractionGrid(grid, cells).Rows
+			filled := copyGrid.fillContinuousArea(xi, yi, '*')
+			FillCellsWith(fillBuffer.Rows, filled, '*')
+			FillCellsWith(fillBuffer.Rows, boundaries, '-')
+		}
+	}
+	return result
+}
+
+func distinctShapesOf(grid *TextGrid) []*CellSet {
+	grid = CopyTextGrid(grid)
+	grid.ReplaceTypeOnLine()
+	grid.ReplacePointMarkersOnLine()
+	return getDistinctShapes(NewAbstractionGrid(grid, getAllBoundaries(grid)))
+}
+
+func TestFindBoundarySets(t *testing.T) {
+	in := &interpreter{ctx: context.Background()}
+	for _, name := range []string{"simple_square01.txt", "simple_U01.txt", "art2.txt", "art10.txt", "bug9.txt", "ditaa_bug2.txt"} {
+		grid := loadTestGrid(t, name)
+		for _, cells := range distinctShapesOf(grid) {
+			got := findBoundarySets(in, grid, cells)
+			want := boundarySetsByFilling(grid, cells)
+			if len(got) != len(want) {
+				t.Errorf("%s: got %d boundaries, want %d", name, len(got), len(want))
+				continue
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i].Set, want[i].Set) {
+					t.Errorf("%s: boundary %d differs", name, i)
+					got[i].printAsGrid()
+					want[i].printAsGrid()
+				}
+			}
+		}
+	}
+}
+
+func TestFindBoundarySetsEmpty(t *testing.T) {
+	in := &interpreter{ctx: context.Background()}
+	grid := loadTestGrid(t, "art2.txt")
+	for _, cells := range distinctShapesOf(grid) {
+		for i, boundary := range findBoundarySets(in, grid, cells) {
+			if len(boundary.Set) == 0 {
+				t.Errorf("art2.txt: boundary %d is empty", i)
+			}
+		}
+	}
+	// text cells have no abstraction, so there is nothing around them
+	text := NewCellSet()
+	text.Add(Cell{1, 1})
+	text.Add(Cell{2, 1})
+	grid = NewTextGrid(4, 3)
+	grid.Set(1, 1, 'a')
+	grid.Set(2, 1, 'b')
+	if got := findBoundarySets(in, grid, text); len(got) != 0 {
+		t.Errorf("text: got %d boundaries, want 0", len(got))
+	}
+	if got := findBoundarySets(in, grid, NewCellSet()); len(got) != 0 {
+		t.Errorf("no cells: got %d boundaries, want 0", len(got))
+	}
+}
+
+func benchmarkBoundarySets(b *testing.B, find func(*TextGrid, *CellSet) []*CellSet) {
+	grid := loadTestGrid(b, "art2.txt")
+	shapes := distinctShapesOf(grid)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, cells := range shapes {
+			find(grid, cells)
+		}
+	}
+}
+
+func BenchmarkFindBoundarySets(b *testing.B) {
+	in := &interpreter{ctx: context.Background()}
+	benchmarkBoundarySets(b, func(grid *TextGrid, cells *CellSet) []*CellSet {
+		return findBoundarySets(in, grid, cells)
+	})
+}
+
+func BenchmarkBoundarySetsByFilling(b *testing.B) {
+	benchmarkBoundarySets(b, boundarySetsByFilling)
+}
@@ -184,6 +184,22 @@ func (s *CellSet) SomeCell() Cell {
 	return Cell{} // [akavel] TODO: or panic("should not reach") ?
 }
 
+// TopLeftCell returns the first cell of the set in reading order
+// (top to bottom, then left to right).
+func (s *CellSet) TopLeftCell() Cell {
+	var first *Cell
+	for c := range s.Set {
+		if first == nil || c.Y < first.Y || (c.Y == first.Y && c.X < first.X) {
+			c := c
+			first = &c
+		}
+	}
+	if first == nil {
+		return Cell{}
+	}
+	return *first
+}
+
 func (s *CellSet) translate(dx, dy int) {
 	s.typ = SET_UNINITIALIZED
 	result := map[Cell]struct{}{}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestCellSetContains(t *testing.T) {
+	set := NewCellSet()
+	set.Add(Cell{10, 20})
+	set.Add(Cell{10, 60})
+	set.Add(Cell{10, 30})
+	set.Add(Cell{60, 20})
+
+	cell1, cell2 := Cell{10, 20}, Cell{10, 20}
+	if cell1 != cell2 {
+		t.Errorf("%v != %v", cell1, cell2)
+	}
+	if !set.Contains(cell1) {
+		t.Errorf("set doesn't contain %v", cell1)
+	}
+	if set.Contains(Cell{20, 10}) {
+		t.Errorf("set contains %v", Cell{20, 10})
+	}
+}
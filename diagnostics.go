@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Severity int
+
+const (
+	SEVERITY_WARNING Severity = iota
+	SEVERITY_ERROR
+)
+
+func (s Severity) String() string {
+	if s == SEVERITY_ERROR {
+		return "error"
+	}
+	return "warning"
+}
+
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// Diagnostic is a problem found while interpreting the source text of
+// a diagram. Line and Col are 1-based and refer to the original source,
+// i.e. the blankBorderSize padding added by TextGrid.LoadFrom is already
+// subtracted; Col counts runes after tabs were expanded.
+type Diagnostic struct {
+	Line     int      `json:"line"`
+	Col      int      `json:"col"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Col, d.Severity, d.Message)
+}
+
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Len() int      { return len(ds) }
+func (ds Diagnostics) Swap(i, j int) { ds[i], ds[j] = ds[j], ds[i] }
+func (ds Diagnostics) Less(i, j int) bool {
+	if ds[i].Line != ds[j].Line {
+		return ds[i].Line < ds[j].Line
+	}
+	return ds[i].Col < ds[j].Col
+}
+
+// Sorted returns the diagnostics in source order, with duplicates removed.
+func (ds Diagnostics) Sorted() Diagnostics {
+	sorted := append(Diagnostics(nil), ds...)
+	sort.Stable(sorted)
+	result := Diagnostics{}
+	for i, d := range sorted {
+		if i > 0 && d == sorted[i-1] {
+			continue
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// HasErrors reports whether any of the diagnostics is an error.
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SEVERITY_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+// Warnf records a warning about the grid cell c.
+func (ds *Diagnostics) Warnf(c Cell, format string, args ...interface{}) {
+	ds.add(SEVERITY_WARNING, c, format, args...)
+}
+
+// Errorf records an error about the grid cell c.
+func (ds *Diagnostics) Errorf(c Cell, format string, args ...interface{}) {
+	ds.add(SEVERITY_ERROR, c, format, args...)
+}
+
+func (ds *Diagnostics) add(sev Severity, c Cell, format string, args ...interface{}) {
+	*ds = append(*ds, Diagnostic{
+		Line:     c.Y - blankBorderSize + 1,
+		Col:      c.X - blankBorderSize + 1,
+		Severity: sev,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
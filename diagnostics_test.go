@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticsSorted(t *testing.T) {
+	b := blankBorderSize
+	ds := Diagnostics{}
+	ds.Warnf(Cell{b + 4, b + 2}, "late %s", "warning")
+	ds.Errorf(Cell{b, b}, "first")
+	ds.Warnf(Cell{b + 1, b + 2}, "early")
+	ds.Warnf(Cell{b + 4, b + 2}, "late %s", "warning")
+	ds.Warnf(Cell{b + 4, b + 2}, "other")
+	if len(ds) != 5 {
+		t.Fatalf("got %d diagnostics, want 5", len(ds))
+	}
+	want := []string{
+		"1:1: error: first",
+		"3:2: warning: early",
+		"3:5: warning: late warning",
+		"3:5: warning: other",
+	}
+	got := []string{}
+	for _, d := range ds.Sorted() {
+		got = append(got, d.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !ds.HasErrors() {
+		t.Errorf("HasErrors: got false, want true")
+	}
+	if ds[2:].HasErrors() {
+		t.Errorf("HasErrors of warnings: got true, want false")
+	}
+}
+
+func TestDiagramDiagnostics(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"+--+\n|  |\n+--+", []string{}},
+		{"+---+\n|{x}|\n+---+", []string{"2:2: warning: unknown markup tag {x}"}},
+		{"{d}\n+--+\n|  |\n+--+", []string{"1:1: warning: markup tag {d} is not inside any shape"}},
+		{"cRED\n\n+--+\n|  |\n+--+", []string{"1:1: warning: color code cRED is not inside any shape, next to any line or before any text"}},
+		{"  ^\n\n+--+\n|  |\n+--+", []string{"1:3: warning: arrowhead '^' is not attached to any line"}},
+		{"+--+\n|  |---\n+--+", []string{"2:7: warning: line is not connected to anything at this end"}},
+	}
+	for _, test := range tests {
+		grid := NewTextGrid(0, 0)
+		grid.LoadFrom(strings.NewReader(test.text))
+		d, err := NewDiagram(grid)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		got := []string{}
+		for _, diag := range d.Diagnostics.Sorted() {
+			got = append(got, diag.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
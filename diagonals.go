@@ -0,0 +1,191 @@
+package main
+
+import (
+	"github.com/akavel/ditaa/graphical"
+)
+
+/*
+Diagonal lines are runs of '/' or '\' characters, each one cell to the
+south-west (for '/') or south-east (for '\') of the previous one, which
+aren't corners of other shapes:
+
+	+---+       ^
+	| A |      /
+	+---+     /
+	     \   /
+	      v +---+
+	        | B |
+	        +---+
+
+They may end in an arrowhead: one of '^', 'v', '<' and '>' pointing away
+from the line, or one of the diagonal arrows '↖', '↗', '↘' and '↙'. A
+single character is only a line if both of its ends meet a corner, the
+end of another line or such an arrowhead, so that the slashes in text,
+even in the one-line boxes, are left alone.
+*/
+
+// diagonalArrowheads maps the directions of the diagonal arrowheads to
+// their characters.
+var diagonalArrowheads = map[Cell]rune{
+	{-1, -1}: '↖',
+	{1, -1}:  '↗',
+	{1, 1}:   '↘',
+	{-1, 1}:  '↙',
+}
+
+// diagonalRun is a run of diagonal line characters, from its north end to
+// its south end.
+type diagonalRun struct {
+	Cells []Cell
+	Step  Cell // from a cell of the run to the next one
+}
+
+func (r diagonalRun) north() Cell { return r.Cells[0] }
+func (r diagonalRun) south() Cell { return r.Cells[len(r.Cells)-1] }
+
+// diagonalStep returns the step to the next cell of the diagonal line at
+// c, if it holds one of the characters of lines.
+func (t *TextGrid) diagonalStep(c Cell, lines string) (Cell, bool) {
+	var step Cell
+	switch ch := t.GetCell(c); {
+	case !isOneOf(ch, lines):
+		return Cell{}, false
+	case ch == '\\' || ch == '╲':
+		step = Cell{1, 1}
+	default:
+		step = Cell{-1, 1}
+	}
+	if t.IsBoundary(c) {
+		return Cell{}, false // a corner of a shape
+	}
+	return step, true
+}
+
+func (t *TextGrid) findDiagonalRuns(lines string) []diagonalRun {
+	runs := []diagonalRun{}
+	for y := range t.Rows {
+		for x := range t.Rows[y] {
+			c := Cell{x, y}
+			step, ok := t.diagonalStep(c, lines)
+			if !ok {
+				continue
+			}
+			if prev, ok := t.diagonalStep(Cell{x - step.X, y - step.Y}, lines); ok && prev == step {
+				continue // not the north end of a run
+			}
+			run := diagonalRun{Step: step}
+			for next, ok := step, true; ok && next == step; next, ok = t.diagonalStep(c, lines) {
+				run.Cells = append(run.Cells, c)
+				c = Cell{c.X + step.X, c.Y + step.Y}
+			}
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// isArrowheadTowards tells if there is an arrowhead at c pointing in the
+// direction dir, which a diagonal line can end in.
+func (t *TextGrid) isArrowheadTowards(c, dir Cell) bool {
+	ch := t.GetCell(c)
+	if ch == diagonalArrowheads[dir] {
+		return true
+	}
+	switch {
+	case ch == '^' && dir.Y < 0, isOneOf(ch, "vV") && dir.Y > 0,
+		ch == '<' && dir.X < 0, ch == '>' && dir.X > 0:
+		// unless it is the arrowhead of a horizontal or vertical line
+		tail := t.ArrowheadTail(c)
+		return tail == c || !t.IsBoundary(tail)
+	}
+	return false
+}
+
+// anchorsDiagonal tells if a diagonal line ending at c, going in the
+// direction dir, meets a corner, the end of another line or an arrowhead
+// pointing along it in the next cell.
+func (t *TextGrid) anchorsDiagonal(c, dir Cell) bool {
+	ahead := Cell{c.X + dir.X, c.Y + dir.Y}
+	return t.IsCorner(ahead) || t.IsLinesEnd(ahead) || t.isArrowheadTowards(ahead, dir)
+}
+
+// ReplaceDiagonalLines replaces the characters of the diagonal lines with
+// '╱' and '╲', and their arrowheads with the diagonal arrows, so that they
+// are not taken for the corners of other shapes any more.
+func (t *TextGrid) ReplaceDiagonalLines() {
+	type change struct {
+		c  Cell
+		ch rune
+	}
+	changes := []change{}
+	for _, run := range t.findDiagonalRuns(`/\` + text_diagonalLines) {
+		ends := []struct{ c, dir Cell }{
+			{run.north(), Cell{-run.Step.X, -run.Step.Y}},
+			{run.south(), run.Step},
+		}
+		if len(run.Cells) == 1 && !(t.anchorsDiagonal(ends[0].c, ends[0].dir) && t.anchorsDiagonal(ends[1].c, ends[1].dir)) {
+			continue
+		}
+		ch := '╲'
+		if run.Step.X < 0 {
+			ch = '╱'
+		}
+		for _, c := range run.Cells {
+			changes = append(changes, change{c, ch})
+		}
+		for _, end := range ends {
+			ahead := Cell{end.c.X + end.dir.X, end.c.Y + end.dir.Y}
+			if t.isArrowheadTowards(ahead, end.dir) {
+				changes = append(changes, change{ahead, diagonalArrowheads[end.dir]})
+			}
+		}
+	}
+	for _, ch := range changes {
+		t.SetCell(ch.c, ch.ch)
+	}
+}
+
+// createDiagonalLines makes open shapes of the diagonal lines of a grid
+// prepared with ReplaceDiagonalLines. A line spans its cells from corner
+// to corner, except where it continues another line sideways:
+//
+//	----╲
+//	     ╲
+//
+// Then it starts at the center of the end of the other line.
+func createDiagonalLines(grid *TextGrid, gg graphical.Grid) []graphical.Shape {
+	shapes := []graphical.Shape{}
+	for _, run := range grid.findDiagonalRuns(text_diagonalLines) {
+		points := diagonalEnd(grid, run.north(), Cell{-run.Step.X, -run.Step.Y}, gg)
+		south := diagonalEnd(grid, run.south(), run.Step, gg)
+		for i := len(south) - 1; i >= 0; i-- {
+			points = append(points, south[i])
+		}
+		shapes = append(shapes, *graphical.NewShape(points...))
+	}
+	return shapes
+}
+
+// diagonalEnd returns the points of the end of a diagonal line at c going
+// in the direction dir, from the outermost one.
+func diagonalEnd(grid *TextGrid, c, dir Cell, gg graphical.Grid) []graphical.Point {
+	cc := graphical.Cell(c)
+	center := graphical.Point{X: gg.CellMidX(cc), Y: gg.CellMidY(cc)}
+	if grid.IsBlankXY(c.X+dir.X, c.Y+dir.Y) {
+		for _, side := range []Cell{{c.X + dir.X, c.Y}, {c.X, c.Y + dir.Y}} {
+			if grid.IsBoundary(side) {
+				sc := graphical.Cell(side)
+				joint := graphical.Point{X: gg.CellMidX(sc), Y: gg.CellMidY(sc)}
+				return []graphical.Point{joint, center}
+			}
+		}
+	}
+	corner := graphical.Point{X: gg.CellMinX(cc), Y: gg.CellMinY(cc)}
+	if dir.X > 0 {
+		corner.X = gg.CellMaxX(cc)
+	}
+	if dir.Y > 0 {
+		corner.Y = gg.CellMaxY(cc)
+	}
+	return []graphical.Point{corner}
+}
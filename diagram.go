@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"math"
+	"sort"
 
 	"code.google.com/p/jamslam-freetype-go/freetype"
 	"code.google.com/p/jamslam-freetype-go/freetype/truetype"
@@ -22,6 +24,8 @@ var baseFont = func() *truetype.Font {
 
 type Diagram struct {
 	G graphical.Diagram
+	// Diagnostics lists the problems found while interpreting the grid.
+	Diagnostics Diagnostics
 }
 
 /*
@@ -67,14 +71,82 @@ proceed with using those boundaries to create the shapes:
   5. Create arrowheads.
   6. Create point markers.
 
+Anything that looks suspicious along the way (e.g. arrowheads not
+attached to any line, or color codes outside of any shape) is recorded
+in the Diagnostics of the resulting Diagram. Input which cannot be
+interpreted at all (e.g. a cell which is part of a boundary, but whose
+type cannot be determined) makes NewDiagram return an *InterpretError.
+
 Finally, the text processing occurs: [pending]
 
 */
-func NewDiagram(grid *TextGrid) *Diagram {
+func NewDiagram(grid *TextGrid) (*Diagram, error) {
+	return NewDiagramContext(context.Background(), grid, ProcessingOptions{})
+}
+
+// ProcessingOptions control how NewDiagramContext interprets the grid.
+type ProcessingOptions struct {
+	Limits
+	// AllCornersRound makes the corners drawn with '+' round, like the
+	// ones drawn with '/' and '\'. The {round} and {sharp} markup tags
+	// override it for single shapes.
+	AllCornersRound bool
+	// Arrowheads is the style of the arrowheads drawn with '^', 'v', '<'
+	// and '>'. The other endings of lines have styles of their own.
+	Arrowheads graphical.ArrowheadStyle
+	// Theme gives the colors of the lines and text, and the palette of
+	// the named color codes; nil means graphical.DefaultTheme.
+	Theme *graphical.Theme
+}
+
+// NewDiagramContext is like NewDiagram, but gives up as soon as the
+// context is done, returning its error, or when the grid exceeds the
+// limits, returning a *LimitError.
+func NewDiagramContext(ctx context.Context, grid *TextGrid, opt ProcessingOptions) (d *Diagram, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		a, ok := r.(abort)
+		if !ok {
+			panic(r)
+		}
+		d, err = nil, a.err
+	}()
+	in := &interpreter{ctx: ctx, limits: opt.Limits, allCornersRound: opt.AllCornersRound, arrowheads: opt.Arrowheads, theme: opt.Theme}
+	if in.theme == nil {
+		in.theme = graphical.DefaultTheme()
+	}
+	in.checkLimit("width", opt.MaxWidth, grid.Width()-2*blankBorderSize)
+	in.checkLimit("height", opt.MaxHeight, grid.Height()-2*blankBorderSize)
+	return in.interpret(grid), nil
+}
+
+// InterpretError is a problem with the input grid which makes it
+// impossible to interpret. The code finding it aborts the interpretation
+// (see failf), and NewDiagram returns it as an error.
+type InterpretError struct {
+	Cell    Cell
+	Message string
+}
+
+func (e *InterpretError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Cell.Y-blankBorderSize+1, e.Cell.X-blankBorderSize+1, e.Message)
+}
+
+// failf aborts NewDiagram with an InterpretError about the grid cell c.
+func failf(c Cell, format string, args ...interface{}) {
+	panic(abort{&InterpretError{Cell: c, Message: fmt.Sprintf(format, args...)}})
+}
+
+func (in *interpreter) interpret(grid *TextGrid) *Diagram {
+	diags := Diagnostics{}
 
 	workGrid := CopyTextGrid(grid)
 	workGrid.ReplaceTypeOnLine()
 	workGrid.ReplacePointMarkersOnLine()
+	workGrid.ReplaceDiagonalLines()
 
 	if DEBUG {
 		fmt.Print(workGrid.DEBUG())
@@ -92,39 +164,15 @@ func NewDiagram(grid *TextGrid) *Diagram {
 		fmt.Println("******* Same set of shapes after processing them by filling *******")
 	}
 
-	//Find all the boundaries by using the special version of the filling method
-	//(fills in a different buffer than the buffer it reads from)
-	w, h := grid.Width(), grid.Height()
+	//Find all the boundaries by labelling the blank areas of the
+	//abstraction of each shape
 	boundarySetsStep2 := []*CellSet{}
 	for _, cells := range boundarySetsStep1 {
-		//the fill buffer keeps track of which cells have been
-		//filled already
-		fillBuffer := NewTextGrid(3*w, 3*h)
-
-		for yi := 0; yi < 3*h; yi++ {
-			for xi := 0; xi < 3*w; xi++ {
-				if !fillBuffer.IsBlankXY(xi, yi) {
-					continue
-				}
-
-				copyGrid := NewTextGrid(0, 0)
-				copyGrid.Rows = NewAbstractionGrid(workGrid, cells).Rows
-
-				boundaries := findBoundariesExpandingFrom(copyGrid, Cell{xi, yi})
-				if len(boundaries.Set) == 0 {
-					continue //i'm not sure why these occur
-				}
-				boundarySetsStep2 = append(boundarySetsStep2, makeScaledOneThirdEquivalent(boundaries))
-
-				copyGrid.Rows = NewAbstractionGrid(workGrid, cells).Rows
-				filled := copyGrid.fillContinuousArea(xi, yi, '*')
-				FillCellsWith(fillBuffer.Rows, filled, '*')
-				FillCellsWith(fillBuffer.Rows, boundaries, '-')
-
-				if DEBUG {
-					makeScaledOneThirdEquivalent(boundaries).printAsGrid()
-					fmt.Println("-----------------------------------")
-				}
+		for _, boundaries := range findBoundarySets(in, workGrid, cells) {
+			boundarySetsStep2 = append(boundarySetsStep2, boundaries)
+			if DEBUG {
+				boundaries.printAsGrid()
+				fmt.Println("-----------------------------------")
 			}
 		}
 	}
@@ -166,6 +214,7 @@ func NewDiagram(grid *TextGrid) *Diagram {
 		// +-----+
 		hadToEliminateMixed = true
 		for _, set := range mixed {
+			diags.Warnf(set.TopLeftCell(), "mixed open/closed boundary had to be broken up heuristically")
 			boundarySetsStep2 = remove(boundarySetsStep2, set)
 			boundarySetsStep2 = append(boundarySetsStep2, breakTrulyMixedBoundaries(set, workGrid)...)
 		}
@@ -176,9 +225,10 @@ func NewDiagram(grid *TextGrid) *Diagram {
 	}
 
 	closed = removeObsoleteShapes(workGrid, closed)
+	in.checkContext()
+	in.checkLimit("shape count", in.limits.MaxShapes, len(closed)+len(open))
 
-	//TODO: handle allCornersRound commandline option
-	allCornersRound := false
+	allCornersRound := in.allCornersRound
 
 	d := Diagram{}
 	d.G.Grid = graphical.Grid{
@@ -205,7 +255,7 @@ func NewDiagram(grid *TextGrid) *Diagram {
 	//TODO: handle opt.performSeparationOfCommonEdges
 	if true { // TODO: enabled by default, disabled if opt.performSeparationOfCommonEdges != default true
 		// FIXME(akavel): as of now, we have only closed shapes here, but this might change with compositeShapes
-		d.G.Shapes = separateCommonEdges(d.G.Grid, d.G.Shapes)
+		d.G.Shapes = separateCommonEdges(in, d.G.Grid, d.G.Shapes)
 		if DEBUG {
 			fmt.Println("closed shapes:")
 			fmt.Printf("%#v\n", d.G.Shapes)
@@ -222,8 +272,9 @@ func NewDiagram(grid *TextGrid) *Diagram {
 			}
 			shape := NewSmallLine(workGrid, c, d.G.Grid)
 			if shape != nil {
+				loose := ConnectEndsToAnchors(shape, workGrid, d.G.Grid)
 				d.G.Shapes = append(d.G.Shapes, *shape)
-				ConnectEndsToAnchors(shape, workGrid, d.G.Grid)
+				warnUnterminated(&diags, loose)
 			}
 		default: //normal shape
 			if DEBUG {
@@ -232,37 +283,126 @@ func NewDiagram(grid *TextGrid) *Diagram {
 			shapes := createOpenFromBoundaryCells(workGrid, set, d.G.Grid, allCornersRound)
 			for i := range shapes {
 				if !shapes[i].Closed {
-					ConnectEndsToAnchors(&shapes[i], workGrid, d.G.Grid)
+					loose := ConnectEndsToAnchors(&shapes[i], workGrid, d.G.Grid)
+					warnUnterminated(&diags, loose)
 				}
 			}
 			d.G.Shapes = append(d.G.Shapes, shapes...)
 		}
 	}
+	d.G.Shapes = joinCrossingLines(d.G.Shapes, workGrid, d.G.Grid)
 
-	//assign color codes to shapes
-	//TODO: text on line should not change its color
+	//make diagonal lines
+	for _, shape := range createDiagonalLines(workGrid, d.G.Grid) {
+		loose := ConnectEndsToAnchors(&shape, workGrid, d.G.Grid)
+		warnUnterminated(&diags, loose)
+		d.G.Shapes = append(d.G.Shapes, shape)
+	}
+
+	//assign markup to shapes; this is done before the color codes, so
+	//that they are found inside the actual outlines, e.g. of ellipses
 
-	for _, pair := range grid.FindColorCodes() {
+	alignments := []CellTagPair{} // applied to the text below
+	for _, pair := range grid.findAllMarkupTags() {
+		typ, isType := markupTags[pair.Tag]
+		corners, isCorners := cornerTags[pair.Tag]
+		if _, isAlign := alignTags[pair.Tag]; isAlign {
+			alignments = append(alignments, pair)
+			continue
+		}
+		if !isType && !isCorners {
+			diags.Warnf(pair.Cell, "unknown markup tag {%s}", pair.Tag)
+			continue
+		}
 		c := graphical.Cell(pair.Cell)
 		p := graphical.Point{X: d.G.Grid.CellMidX(c), Y: d.G.Grid.CellMidY(c)}
 		containingShape := FindSmallestShapeContaining(p, d.G.Shapes)
-		if containingShape != nil {
-			color := pair.Color
-			containingShape.FillColor = &color
+		if containingShape == nil {
+			diags.Warnf(pair.Cell, "markup tag {%s} is not inside any shape", pair.Tag)
+			continue
+		}
+		if isCorners {
+			setCorners(containingShape, corners)
+			continue
 		}
+		containingShape.Type = typ
 	}
 
-	//assign markup to shapes
+	//assign color codes: the ones next to a line color the line and its
+	//arrowheads, the ones inside a shape fill it, and the other ones color
+	//the text they are followed by
+	//TODO: text on line should not change its color
 
-	//[MC] TODO: markup shapes
+	colored := map[*graphical.Shape]CellColorPair{}
+	coloredLines := map[int]CellColorPair{} // by the index of the shape
+	coloredText := map[Cell]graphical.Color{}
+	for _, pair := range grid.FindColorCodes(in.theme.Palette) {
+		if lines := findLinesBeside(pair, d.G.Shapes, d.G.Grid); len(lines) > 0 {
+			for _, i := range lines {
+				if prev, ok := coloredLines[i]; ok {
+					diags.Warnf(pair.Cell, "color code %s overrides color code %s of the same line", pair.Code, prev.Code)
+				}
+				coloredLines[i] = pair
+			}
+			continue
+		}
+		c := graphical.Cell(pair.Cell)
+		p := graphical.Point{X: d.G.Grid.CellMidX(c), Y: d.G.Grid.CellMidY(c)}
+		containingShape := FindSmallestShapeContaining(p, d.G.Shapes)
+		if containingShape == nil {
+			next := Cell{pair.Cell.X + len(pair.Code), pair.Cell.Y}
+			if grid.IsBlank(next) {
+				next = next.East()
+			}
+			if ch := grid.GetCell(next); ch != 0 && ch != ' ' && !grid.IsBoundary(next) {
+				coloredText[next] = pair.Color
+				continue
+			}
+			diags.Warnf(pair.Cell, "color code %s is not inside any shape, next to any line or before any text", pair.Code)
+			continue
+		}
+		if prev, ok := colored[containingShape]; ok {
+			diags.Warnf(pair.Cell, "color code %s overrides color code %s of the same shape", pair.Code, prev.Code)
+		}
+		colored[containingShape] = pair
+		color := pair.Color
+		containingShape.FillColor = &color
+	}
 
 	//make arrowheads
 	for _, c := range workGrid.FindArrowheads() {
-		s := createArrowhead(workGrid, c, d.G.Grid)
+		tail := workGrid.ArrowheadTail(c)
+		if workGrid.IsArrowhead(tail) {
+			continue // the second cell of <>
+		}
+		if !workGrid.IsBoundary(tail) && !workGrid.IsDiagonalLine(tail) {
+			diags.Warnf(c, "arrowhead %q is not attached to any line", workGrid.GetCell(c))
+		}
+		s := createArrowhead(workGrid, c, d.G.Grid, in.arrowheads)
 		if s != nil {
+			p := graphical.Point{X: d.G.Grid.CellMidX(graphical.Cell(tail)), Y: d.G.Grid.CellMidY(graphical.Cell(tail))}
+			for _, i := range findLinesNear(p, d.G.Shapes, d.G.Grid) {
+				if pair, ok := coloredLines[i]; ok {
+					coloredLines[len(d.G.Shapes)] = pair
+				}
+			}
 			d.G.Shapes = append(d.G.Shapes, *s)
 		} else {
-			fmt.Fprintf(os.Stderr, "Could not create arrowhead shape. Unexpected error.")
+			diags.Warnf(c, "could not create arrowhead shape")
+		}
+	}
+
+	//draw the lines and arrowheads in the foreground color, unless they
+	//have a color code
+	for i := range d.G.Shapes {
+		s := &d.G.Shapes[i]
+		color := in.theme.Foreground
+		if pair, ok := coloredLines[i]; ok {
+			color = pair.Color
+		}
+		s.StrokeColor = color
+		if s.Type == graphical.TYPE_ARROWHEAD {
+			s.FillColor = &color
 		}
 	}
 
@@ -271,10 +411,13 @@ func NewDiagram(grid *TextGrid) *Diagram {
 	//[MC] TODO: point markers
 
 	d.G.Shapes = removeDuplicateShapes(d.G.Shapes)
+	in.checkLimit("shape count", in.limits.MaxShapes, len(d.G.Shapes))
+	in.checkContext()
 
 	//copy again
 	workGrid = CopyTextGrid(grid)
-	workGrid.RemoveNonText()
+	workGrid.ReplaceDiagonalLines()
+	workGrid.RemoveNonText(in.theme.Palette)
 
 	// ****** handle text *******
 	//break up text into groups
@@ -290,55 +433,39 @@ func NewDiagram(grid *TextGrid) *Diagram {
 		fmt.Println(len(textGroups), "text groups found")
 	}
 
+	//the alignment tags followed by text align its block, the other ones
+	//all the text in their shapes
+	textAligns := map[Cell]TextAlign{}
+	shapeAligns := map[*graphical.Shape]TextAlign{}
+	for _, pair := range alignments {
+		next := Cell{pair.Cell.X + len(pair.Tag) + 2, pair.Cell.Y}
+		if workGrid.IsBlank(next) {
+			next = next.East()
+		}
+		if ch := workGrid.GetCell(next); ch != 0 && ch != ' ' {
+			textAligns[next] = alignTags[pair.Tag]
+			continue
+		}
+		c := graphical.Cell(pair.Cell)
+		p := graphical.Point{X: d.G.Grid.CellMidX(c), Y: d.G.Grid.CellMidY(c)}
+		containingShape := FindSmallestShapeContaining(p, d.G.Shapes)
+		if containingShape == nil {
+			diags.Warnf(pair.Cell, "markup tag {%s} is not inside any shape or before any text", pair.Tag)
+			continue
+		}
+		shapeAligns[containingShape] = alignTags[pair.Tag]
+	}
+
 	font := fontmeasure.GetFontForHeight(baseFont, d.G.Grid.CellH)
 
+	w, h := grid.Width(), grid.Height()
 	for _, textGroupCellSet := range textGroups {
+		in.checkContext()
 		isolationGrid := NewTextGrid(w, h)
 		CopySelectedCells(isolationGrid, textGroupCellSet, workGrid)
-		strings := isolationGrid.FindStrings()
-		for _, pair := range strings {
-			cell := graphical.Cell(pair.C)
-			s := pair.S
-			if DEBUG {
-				fmt.Println("Found string", s)
-			}
-			lastCell := graphical.Cell{cell.X + len(s) - 1, cell.Y}
-
-			minX := d.G.Grid.CellMinX(cell)
-			y := d.G.Grid.CellMaxY(cell)
-			maxX := d.G.Grid.CellMaxX(lastCell)
-
-			textObject := graphical.Label{
-				Text:     s,
-				FontSize: font.Size,
-				X:        int(minX + 0.5),
-				Y:        int(y + 0.5),
-				Color:    graphical.Color{A: 255},
-			}
-			if float64(font.WidthFor(s)) > maxX-minX { // does not fit horizontally
-				lessWideFont := fontmeasure.GetFontForWidth(baseFont, int(maxX-minX+0.5), s)
-				textObject.FontSize = lessWideFont.Size
-			}
-
-			textObject.CenterVerticallyBetween(int(d.G.Grid.CellMinY(cell)), int(d.G.Grid.CellMaxY(cell)), font)
-
-			//TODO: if the strings start with bullets they should be aligned to the left
-
-			// position text correctly
-			otherStart := isolationGrid.OtherStringsStartInTheSameColumn(Cell(cell))
-			otherEnd := isolationGrid.OtherStringsEndInTheSameColumn(Cell(lastCell))
-			if otherStart == 0 && otherEnd == 0 {
-				textObject.CenterHorizontallyBetween(int(minX), int(maxX), font)
-			} else if otherEnd > 0 && otherStart == 0 {
-				textObject.AlignRightEdgeTo(int(maxX), font)
-			} else if otherEnd > 0 && otherStart > 0 {
-				if otherEnd > otherStart {
-					textObject.AlignRightEdgeTo(int(maxX), font)
-				} else if otherEnd == otherStart {
-					textObject.CenterHorizontallyBetween(int(minX), int(maxX), font)
-				}
-			}
-			d.G.Labels = append(d.G.Labels, textObject)
+		for _, block := range findTextBlocks(isolationGrid.FindStrings()) {
+			align, tagged := blockAlign(&d, block, textAligns, shapeAligns)
+			in.addTextBlock(&d, block, align, tagged, isolationGrid, coloredText, font)
 		}
 	}
 	// for _, l := range d.G.Labels {
@@ -350,26 +477,125 @@ func NewDiagram(grid *TextGrid) *Diagram {
 	}
 
 	//correct the color of the text objects according
-	//to the underlying color
+	//to the underlying color, unless they have a color code
 	//[MC] TODO
 
 	for i := range d.G.Labels {
 		label := &d.G.Labels[i]
+		if label.Color != in.theme.Foreground {
+			continue
+		}
 		// FIXME(akavel): fix all usages of DPI/dpi
 		tmpFont := &fontmeasure.Font{Font: baseFont, DPI: 72}
 		shape := FindSmallestShapeIntersecting(label.BoundsFor(tmpFont), d.G.Shapes)
-		if shape == nil || shape.FillColor == nil || !IsDark(*shape.FillColor) {
+		if shape == nil || shape.FillColor == nil || IsDark(*shape.FillColor) == IsDark(in.theme.Background) {
 			continue
 		}
-		label.Color = graphical.WHITE
+		label.Color = in.theme.InverseText
 	}
 
 	//set outline to true for test within custom shapes
 	//[MC] TODO
 
+	d.Diagnostics = diags.Sorted()
 	return &d
 }
 
+// blockAlign returns the alignment of the text block set by a markup tag
+// before one of its lines, or else in the shape containing it, or else
+// guessed from its layout; tagged tells which.
+func blockAlign(d *Diagram, block textBlock, textAligns map[Cell]TextAlign, shapeAligns map[*graphical.Shape]TextAlign) (align TextAlign, tagged bool) {
+	for _, l := range block.Lines {
+		if align, ok := textAligns[l.C]; ok {
+			return align, true
+		}
+	}
+	c := graphical.Cell(block.Lines[0].C)
+	p := graphical.Point{X: d.G.Grid.CellMidX(c), Y: d.G.Grid.CellMidY(c)}
+	if shape := FindSmallestShapeContaining(p, d.G.Shapes); shape != nil {
+		if align, ok := shapeAligns[shape]; ok {
+			return align, true
+		}
+	}
+	return block.guessAlign(), false
+}
+
+// addTextBlock makes the labels of the lines of the text block, aligned
+// as given. The lines aligned by a markup tag are flush with the edge of
+// the block; otherwise each keeps its own edge, e.g. its indentation. The
+// single lines aligned automatically are centered, unless other strings of
+// the text group start or end in the same column.
+func (in *interpreter) addTextBlock(d *Diagram, block textBlock, align TextAlign, tagged bool, isolationGrid *TextGrid, coloredText map[Cell]graphical.Color, font *fontmeasure.Font) {
+	first, last := block.span()
+	blockMinX := d.G.Grid.CellMinX(graphical.Cell{X: first, Y: 0})
+	blockMaxX := d.G.Grid.CellMaxX(graphical.Cell{X: last, Y: 0})
+	for _, pair := range block.Lines {
+		cell := graphical.Cell(pair.C)
+		s := pair.S
+		if DEBUG {
+			fmt.Println("Found string", s)
+		}
+		lastCell := graphical.Cell{X: pair.end(), Y: cell.Y}
+
+		minX := d.G.Grid.CellMinX(cell)
+		y := d.G.Grid.CellMaxY(cell)
+		maxX := d.G.Grid.CellMaxX(lastCell)
+
+		textObject := graphical.Label{
+			Text:     s,
+			FontSize: font.Size,
+			X:        int(minX + 0.5),
+			Y:        int(y + 0.5),
+			Color:    in.theme.Foreground,
+		}
+		if color, ok := coloredText[Cell(cell)]; ok {
+			textObject.Color = color
+		}
+		if float64(font.WidthFor(s)) > maxX-minX { // does not fit horizontally
+			lessWideFont := fontmeasure.GetFontForWidth(baseFont, int(maxX-minX+0.5), s)
+			textObject.FontSize = lessWideFont.Size
+		}
+
+		textObject.CenterVerticallyBetween(int(d.G.Grid.CellMinY(cell)), int(d.G.Grid.CellMaxY(cell)), font)
+
+		// position text correctly
+		switch {
+		case align == ALIGN_LEFT && tagged:
+			textObject.X = int(blockMinX + 0.5)
+		case align == ALIGN_LEFT:
+		case align == ALIGN_RIGHT && tagged:
+			textObject.AlignRightEdgeTo(int(blockMaxX), font)
+		case align == ALIGN_RIGHT:
+			textObject.AlignRightEdgeTo(int(maxX), font)
+		case align == ALIGN_CENTER:
+			textObject.X = int(blockMinX + 0.5)
+			textObject.CenterHorizontallyBetween(int(blockMinX), int(blockMaxX), font)
+		default:
+			otherStart := isolationGrid.OtherStringsStartInTheSameColumn(Cell(cell))
+			otherEnd := isolationGrid.OtherStringsEndInTheSameColumn(Cell(lastCell))
+			if otherStart == 0 && otherEnd == 0 {
+				textObject.CenterHorizontallyBetween(int(minX), int(maxX), font)
+			} else if otherEnd > 0 && otherStart == 0 {
+				textObject.AlignRightEdgeTo(int(maxX), font)
+			} else if otherEnd > 0 && otherStart > 0 {
+				if otherEnd > otherStart {
+					textObject.AlignRightEdgeTo(int(maxX), font)
+				} else if otherEnd == otherStart {
+					textObject.CenterHorizontallyBetween(int(minX), int(maxX), font)
+				}
+			}
+		}
+		d.G.Labels = append(d.G.Labels, textObject)
+		in.checkLimit("label count", in.limits.MaxLabels, len(d.G.Labels))
+	}
+}
+
+func warnUnterminated(diags *Diagnostics, ends []Cell) {
+	for _, c := range ends {
+		diags.Warnf(c, "line is not connected to anything at this end")
+	}
+}
+
 func removeDuplicateShapes(shapes []graphical.Shape) []graphical.Shape {
 	origShapes := []graphical.Shape{}
 	for _, s := range shapes {
@@ -390,7 +616,7 @@ func removeDuplicateShapes(shapes []graphical.Shape) []graphical.Shape {
 
 func createClosedComponentFromBoundaryCells(grid *TextGrid, cells *CellSet, gg graphical.Grid, allCornersRound bool) *graphical.Shape {
 	if cells.Type(grid) == SET_OPEN {
-		panic("CellSet is open and cannot be handled by this method")
+		failf(cells.TopLeftCell(), "open boundary cannot be made into a shape")
 	}
 	if len(cells.Set) < 2 {
 		return nil
@@ -424,15 +650,13 @@ func createClosedComponentFromBoundaryCells(grid *TextGrid, cells *CellSet, gg g
 
 	for cell != start {
 		nextCells = workGrid.FollowCell(cell, &prev)
-		if len(nextCells.Set) > 1 {
-			return nil
-		}
-		if len(nextCells.Set) == 1 {
-			prev = cell
-			cell = nextCells.SomeCell()
-			if cell != start && workGrid.IsCorner(cell) {
-				shape.Points = append(shape.Points, makePointForCell(cell, workGrid, gg, allCornersRound))
-			}
+		if len(nextCells.Set) != 1 {
+			return nil // dead end or branch, not a simple closed shape
+		}
+		prev = cell
+		cell = nextCells.SomeCell()
+		if cell != start && workGrid.IsCorner(cell) {
+			shape.Points = append(shape.Points, makePointForCell(cell, workGrid, gg, allCornersRound))
 		}
 	}
 
@@ -659,9 +883,12 @@ func getDistinctShapes(g *AbstractionGrid) []*CellSet {
 
 	distinct := breakIntoDistinctBoundaries(nonBlank)
 	for _, set := range distinct {
-		temp := EmptyAbstractionGrid(g.Width(), g.Height())
-		FillCellsWith(temp.Rows, set, '*')
-		result = append(result, temp.GetAsTextGrid().GetAllNonBlank())
+		// scale the set back to the cells of the text grid
+		scaled := NewCellSet()
+		for c := range set.Set {
+			scaled.Add(Cell{c.X / 3, c.Y / 3})
+		}
+		result = append(result, scaled)
 	}
 	return result
 }
@@ -723,7 +950,7 @@ func breakTrulyMixedBoundaries(cells *CellSet, grid *TextGrid) []*CellSet {
 		prev := start
 		nexts := workGrid.FollowCell(prev, nil)
 		if len(nexts.Set) == 0 {
-			panic("This shape is either open but multipart or has only one cell, and cannot be processed by this method")
+			failf(start, "line cannot be followed from its end")
 		}
 		cell := nexts.SomeCell()
 		set.Add(cell)
@@ -794,6 +1021,49 @@ func FindSmallestShapeContaining(p graphical.Point, shapes []graphical.Shape) *g
 	return containingShape
 }
 
+// findLinesBeside returns the indices of the lines next to the cells of
+// the color code, above, below, or before or after it.
+func findLinesBeside(pair CellColorPair, shapes []graphical.Shape, gg graphical.Grid) []int {
+	n := len(pair.Code)
+	neighbors := []Cell{{pair.Cell.X - 1, pair.Cell.Y}, {pair.Cell.X + n, pair.Cell.Y}}
+	for i := 0; i < n; i++ {
+		neighbors = append(neighbors, Cell{pair.Cell.X + i, pair.Cell.Y - 1}, Cell{pair.Cell.X + i, pair.Cell.Y + 1})
+	}
+	found := map[int]bool{}
+	result := []int{}
+	for _, c := range neighbors {
+		p := graphical.Point{X: gg.CellMidX(graphical.Cell(c)), Y: gg.CellMidY(graphical.Cell(c))}
+		for _, i := range findLinesNear(p, shapes, gg) {
+			if !found[i] {
+				found[i] = true
+				result = append(result, i)
+			}
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// findLinesNear returns the indices of the lines, i.e. the open shapes
+// other than arrowheads and point markers, passing less than half a cell
+// away from p.
+func findLinesNear(p graphical.Point, shapes []graphical.Shape, gg graphical.Grid) []int {
+	r := math.Min(float64(gg.CellW), float64(gg.CellH)) / 2
+	result := []int{}
+	for i, s := range shapes {
+		if s.Closed || s.Type == graphical.TYPE_ARROWHEAD || s.Type == graphical.TYPE_POINT_MARKER {
+			continue
+		}
+		for j := 1; j < len(s.Points); j++ {
+			if distanceToSegment(p, s.Points[j-1], s.Points[j]) < r {
+				result = append(result, i)
+				break
+			}
+		}
+	}
+	return result
+}
+
 func FindSmallestShapeIntersecting(rect graphical.Rect, shapes []graphical.Shape) *graphical.Shape {
 	var intersectingShape *graphical.Shape
 	for i := range shapes {
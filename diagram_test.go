@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/akavel/ditaa/fontmeasure"
+	"github.com/akavel/ditaa/graphical"
+)
+
+// randomDiagram is a well-formed diagram of boxes, laid out in slots of
+// a table, some of them connected by horizontal and vertical arrows.
+type randomDiagram struct {
+	Text   string
+	Boxes  []CellBounds // in the coordinates of the text
+	Arrows int
+}
+
+const (
+	slotW = 16
+	slotH = 8
+)
+
+func (randomDiagram) Generate(rnd *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(generateDiagram(rnd, 1+rnd.Intn(3), 1+rnd.Intn(3)))
+}
+
+func generateDiagram(rnd *rand.Rand, cols, rows int) randomDiagram {
+	grid := NewTextGrid(cols*slotW, rows*slotH)
+	d := randomDiagram{}
+	boxes := map[[2]int]CellBounds{}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			if rnd.Intn(4) == 0 {
+				continue
+			}
+			w, h := 4+rnd.Intn(slotW-8), 3+rnd.Intn(slotH-5)
+			x := col*slotW + 1 + rnd.Intn(slotW-w-2)
+			y := row*slotH + 1 + rnd.Intn(slotH-h-2)
+			box := CellBounds{Min: Cell{x, y}, Max: Cell{x + w - 1, y + h - 1}}
+			drawTestBox(grid, box, rnd)
+			boxes[[2]int{col, row}] = box
+			d.Boxes = append(d.Boxes, box)
+		}
+	}
+	for pos, a := range boxes {
+		if b, ok := boxes[[2]int{pos[0] + 1, pos[1]}]; ok && rnd.Intn(2) == 0 {
+			y0, y1 := maxInt(a.Min.Y, b.Min.Y)+1, minInt(a.Max.Y, b.Max.Y)-1
+			if y0 <= y1 && b.Min.X-a.Max.X > 3 {
+				y := y0 + rnd.Intn(y1-y0+1)
+				for x := a.Max.X + 1; x < b.Min.X-1; x++ {
+					grid.Set(x, y, '-')
+				}
+				grid.Set(b.Min.X-1, y, '>')
+				d.Arrows++
+			}
+		}
+		if b, ok := boxes[[2]int{pos[0], pos[1] + 1}]; ok && rnd.Intn(2) == 0 {
+			x0, x1 := maxInt(a.Min.X, b.Min.X)+1, minInt(a.Max.X, b.Max.X)-1
+			if x0 <= x1 && b.Min.Y-a.Max.Y > 2 {
+				x := x0 + rnd.Intn(x1-x0+1)
+				for y := a.Max.Y + 1; y < b.Min.Y-1; y++ {
+					grid.Set(x, y, '|')
+				}
+				grid.Set(x, b.Min.Y-1, 'v')
+				d.Arrows++
+			}
+		}
+	}
+	lines := []string{}
+	for _, row := range grid.Rows {
+		lines = append(lines, string(row))
+	}
+	d.Text = strings.Join(lines, "\n")
+	return d
+}
+
+func drawTestBox(grid *TextGrid, box CellBounds, rnd *rand.Rand) {
+	for x := box.Min.X; x <= box.Max.X; x++ {
+		grid.Set(x, box.Min.Y, '-')
+		grid.Set(x, box.Max.Y, '-')
+	}
+	for y := box.Min.Y; y <= box.Max.Y; y++ {
+		grid.Set(box.Min.X, y, '|')
+		grid.Set(box.Max.X, y, '|')
+	}
+	for _, c := range []Cell{box.Min, box.Max, {box.Min.X, box.Max.Y}, {box.Max.X, box.Min.Y}} {
+		grid.SetCell(c, '+')
+	}
+	if box.Max.X-box.Min.X > 5 && rnd.Intn(2) == 0 {
+		grid.WriteStringTo(Cell{box.Min.X + 2, box.Min.Y + 1}, "box")
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// diagramProperties are the invariants which NewDiagram must keep for
+// every randomDiagram.
+var diagramProperties = []struct {
+	name  string
+	check func(rd randomDiagram, grid *TextGrid, d *Diagram) error
+}{
+	{"shape count equals box count", func(rd randomDiagram, grid *TextGrid, d *Diagram) error {
+		if n := len(closedShapes(d)); n != len(rd.Boxes) {
+			return fmt.Errorf("got %d closed shapes, want %d", n, len(rd.Boxes))
+		}
+		return nil
+	}},
+	{"boxes are found", func(rd randomDiagram, grid *TextGrid, d *Diagram) error {
+		found := map[CellBounds]bool{}
+		for _, s := range closedShapes(d) {
+			bb := graphical.Bounds(s.Points)
+			min, max := Cell(d.G.Grid.CellFor(bb.Min)), Cell(d.G.Grid.CellFor(bb.Max))
+			found[CellBounds{
+				Min: Cell{min.X - blankBorderSize, min.Y - blankBorderSize},
+				Max: Cell{max.X - blankBorderSize, max.Y - blankBorderSize},
+			}] = true
+		}
+		for _, box := range rd.Boxes {
+			if !found[box] {
+				return fmt.Errorf("box %v-%v not found", box.Min, box.Max)
+			}
+		}
+		return nil
+	}},
+	{"closed shape points lie on boundary cells", func(rd randomDiagram, grid *TextGrid, d *Diagram) error {
+		for _, s := range closedShapes(d) {
+			for _, p := range s.Points {
+				c := Cell(d.G.Grid.CellFor(p))
+				if !grid.IsBoundary(c) {
+					return fmt.Errorf("point %v of shape is in non-boundary cell %v %q", p, c, grid.GetCell(c))
+				}
+			}
+		}
+		return nil
+	}},
+	{"no duplicate shapes", func(rd randomDiagram, grid *TextGrid, d *Diagram) error {
+		shapes := d.G.Shapes
+		for i := range shapes {
+			for j := i + 1; j < len(shapes); j++ {
+				if shapes[i].Equals(shapes[j]) {
+					return fmt.Errorf("shapes %d and %d are equal", i, j)
+				}
+			}
+		}
+		return nil
+	}},
+	{"arrows are found", func(rd randomDiagram, grid *TextGrid, d *Diagram) error {
+		lines, heads := 0, 0
+		for _, s := range d.G.Shapes {
+			switch {
+			case s.Type == graphical.TYPE_ARROWHEAD:
+				heads++
+			case !s.Closed:
+				lines++
+			}
+		}
+		if lines != rd.Arrows || heads != rd.Arrows {
+			return fmt.Errorf("got %d lines and %d arrowheads, want %d of each", lines, heads, rd.Arrows)
+		}
+		return nil
+	}},
+	{"rendered text re-parses to the same shapes", func(rd randomDiagram, grid *TextGrid, d *Diagram) error {
+		var buf strings.Builder
+		err := WriteText(&d.G, &buf, nil)
+		if err != nil {
+			return err
+		}
+		again := NewTextGrid(0, 0)
+		again.LoadFrom(strings.NewReader(buf.String()))
+		d2, err := NewDiagram(again)
+		if err != nil {
+			return fmt.Errorf("%s in:\n%s", err, buf.String())
+		}
+		if got, want := shapeTypeCounts(d2), shapeTypeCounts(d); !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("got shapes %v, want %v in:\n%s", got, want, buf.String())
+		}
+		return nil
+	}},
+}
+
+// shapeTypeCounts counts the shapes of the diagram by type, and whether
+// they are closed.
+func shapeTypeCounts(d *Diagram) map[string]int {
+	counts := map[string]int{}
+	for _, s := range d.G.Shapes {
+		counts[fmt.Sprintf("%s closed=%v", s.Type, s.Closed)]++
+	}
+	return counts
+}
+
+func closedShapes(d *Diagram) []graphical.Shape {
+	shapes := []graphical.Shape{}
+	for _, s := range d.G.Shapes {
+		if s.Closed && s.Type != graphical.TYPE_ARROWHEAD {
+			shapes = append(shapes, s)
+		}
+	}
+	return shapes
+}
+
+func TestNewDiagramProperties(t *testing.T) {
+	n := 40
+	if testing.Short() {
+		n = 5
+	}
+	err := quick.Check(func(rd randomDiagram) bool {
+		grid := NewTextGrid(0, 0)
+		err := grid.LoadFrom(strings.NewReader(rd.Text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDiagram(grid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok := true
+		for _, p := range diagramProperties {
+			if err := p.check(rd, grid, d); err != nil {
+				t.Errorf("%s: %s", p.name, err)
+				ok = false
+			}
+		}
+		if !ok {
+			t.Logf("diagram:\n%s", rd.Text)
+		}
+		return ok
+	}, &quick.Config{MaxCount: n, Rand: rand.New(rand.NewSource(1))})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// maxFuzzCells limits the size of the grids tried by FuzzNewDiagram, to
+// keep the iterations fast.
+const maxFuzzCells = 40 * 20
+
+func FuzzNewDiagram(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join(testTexts, "*.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf)
+	}
+	f.Add([]byte("+--+  /--\\\n|cRED-->|{s}|\n+--+  \\--/"))
+	f.Add([]byte("+-*-+\n: o |<=+\n+---+  |\n  ^    v\n--+----/"))
+	f.Fuzz(func(t *testing.T, text []byte) {
+		grid := NewTextGrid(0, 0)
+		err := grid.LoadFrom(bytes.NewReader(text))
+		if err != nil || grid.Width()*grid.Height() > maxFuzzCells {
+			return
+		}
+		d, err := NewDiagram(grid)
+		if err != nil {
+			return
+		}
+		img := image.NewRGBA(image.Rect(0, 0, d.G.Grid.W, d.G.Grid.H))
+		graphical.RenderDiagram(img, &d.G, graphical.Options{DropShadows: true}, baseFont)
+	})
+}
+
+func TestNewDiagramContextLimits(t *testing.T) {
+	grid := loadTestGrid(t, "art2.txt")
+	tests := []struct {
+		limits Limits
+		limit  string
+	}{
+		{Limits{MaxWidth: 10}, "width"},
+		{Limits{MaxHeight: 10}, "height"},
+		{Limits{MaxShapes: 5}, "shape count"},
+		{Limits{MaxLabels: 3}, "label count"},
+		{Limits{MaxWidth: 100, MaxHeight: 100, MaxShapes: 100, MaxLabels: 100}, ""},
+	}
+	for _, tt := range tests {
+		_, err := NewDiagramContext(context.Background(), grid, ProcessingOptions{Limits: tt.limits})
+		e, _ := err.(*LimitError)
+		switch {
+		case tt.limit == "" && err != nil:
+			t.Errorf("%+v: unexpected error: %s", tt.limits, err)
+		case tt.limit != "" && (e == nil || e.Limit != tt.limit):
+			t.Errorf("%+v: got error %v, want %s limit exceeded", tt.limits, err, tt.limit)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewDiagramContext(ctx, grid, ProcessingOptions{})
+	if err != context.Canceled {
+		t.Errorf("canceled context: got error %v", err)
+	}
+}
+
+func TestLimitsCheckDiagram(t *testing.T) {
+	tests := []struct {
+		grid   graphical.Grid
+		limits Limits
+		limit  string // "" for no LimitError
+		ok     bool
+	}{
+		{graphical.Grid{W: 140, H: 280, CellW: 10, CellH: 14}, Limits{MaxWidth: 10, MaxHeight: 16}, "", true},
+		{graphical.Grid{W: 150, H: 280, CellW: 10, CellH: 14}, Limits{MaxWidth: 10}, "width", false},
+		{graphical.Grid{W: 140, H: 294, CellW: 10, CellH: 14}, Limits{MaxHeight: 16}, "height", false},
+		{graphical.Grid{W: 140, H: 280, CellW: 0, CellH: 14}, Limits{MaxWidth: 10}, "", false},
+		{graphical.Grid{W: 140, H: 280, CellW: 10, CellH: -14}, Limits{}, "", false},
+		{graphical.Grid{W: 1 << 14, H: 1 << 14, CellW: 10, CellH: 14}, Limits{}, "", true},
+		{graphical.Grid{W: 1 << 15, H: 1 << 14, CellW: 10, CellH: 14}, Limits{}, "pixel count", false},
+	}
+	for _, tt := range tests {
+		err := tt.limits.CheckDiagram(&graphical.Diagram{Grid: tt.grid})
+		e, _ := err.(*LimitError)
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("%+v %+v: unexpected error: %s", tt.grid, tt.limits, err)
+		case !tt.ok && err == nil:
+			t.Errorf("%+v %+v: got no error", tt.grid, tt.limits)
+		case tt.limit != "" && (e == nil || e.Limit != tt.limit):
+			t.Errorf("%+v %+v: got error %v, want %s limit exceeded", tt.grid, tt.limits, err, tt.limit)
+		}
+	}
+}
+
+func TestNewDiagramEllipse(t *testing.T) {
+	grid := NewTextGrid(0, 0)
+	err := grid.LoadFrom(strings.NewReader(`
++-------+
+|cBLK   |
+|  End  |
+|    {o}|
++-------+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDiagram(grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.G.Shapes) != 1 || d.G.Shapes[0].Type != graphical.TYPE_ELLIPSE {
+		t.Fatalf("got shapes %+v, want a single ellipse", d.G.Shapes)
+	}
+	shape := d.G.Shapes[0]
+	if shape.FillColor == nil || *shape.FillColor != (graphical.Color{A: 255}) {
+		t.Errorf("got fill color %v, want BLK", shape.FillColor)
+	}
+	if len(d.G.Labels) != 1 || d.G.Labels[0].Color != graphical.WHITE {
+		t.Errorf("got labels %+v, want a single white label", d.G.Labels)
+	}
+	bb := graphical.Bounds(shape.Points)
+	center := graphical.Point{X: (bb.Min.X + bb.Max.X) / 2, Y: (bb.Min.Y + bb.Max.Y) / 2}
+	if !shape.Contains(center) || shape.Contains(bb.Min) || shape.Contains(bb.Max) {
+		t.Errorf("ellipse %v contains its corners or not its center", bb)
+	}
+	if path := shape.MakeIntoOutline(d.G.Grid, graphical.Options{}); len(path) != 5 {
+		t.Errorf("got outline %v, want 4 curves", path)
+	}
+}
+
+func TestNewDiagramDiagonal(t *testing.T) {
+	text := `+---+
+| A |
++---+
+     \
+      v and/or`
+	grid := NewTextGrid(0, 0)
+	if err := grid.LoadFrom(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDiagram(grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Diagnostics) != 0 {
+		t.Errorf("got diagnostics %v", d.Diagnostics)
+	}
+	var line, head *graphical.Shape
+	for i := range d.G.Shapes {
+		switch s := &d.G.Shapes[i]; {
+		case s.Type == graphical.TYPE_ARROWHEAD:
+			head = s
+		case !s.Closed:
+			line = s
+		}
+	}
+	if line == nil || head == nil {
+		t.Fatalf("got shapes %+v, want a line and an arrowhead", d.G.Shapes)
+	}
+	gg := d.G.Grid
+	corner, arrow := graphical.Cell{X: 6, Y: 4}, graphical.Cell{X: 8, Y: 6}
+	want := []graphical.Point{
+		{X: gg.CellMidX(corner), Y: gg.CellMidY(corner), Locked: true},
+		{X: gg.CellMidX(arrow), Y: gg.CellMidY(arrow), Locked: true},
+	}
+	if !reflect.DeepEqual(line.Points, want) {
+		t.Errorf("got line %v, want %v", line.Points, want)
+	}
+	if ch := arrowheadChar(head.Points); ch != '↘' {
+		t.Errorf("got arrowhead %q, want '↘'", ch)
+	}
+	texts := map[string]bool{}
+	for _, label := range d.G.Labels {
+		texts[label.Text] = true
+	}
+	if !texts["and/or"] {
+		t.Errorf("got labels %+v, want and/or among them", d.G.Labels)
+	}
+}
+
+func TestNewDiagramSlashesInBoxes(t *testing.T) {
+	tests := []struct {
+		text   string
+		labels []string
+	}{
+		{`+---------+
+| usr/bin |
++---------+`, []string{"usr/bin"}},
+		{`+-----------------+
+| /usr/local/bin/ |
++-----------------+`, []string{"/usr/local/bin/"}},
+		{`+------------+
+| a\n escape |
++------------+`, []string{`a\n escape`}},
+		{`+-------------+
+| this and/or |
+| that        |
++-------------+`, []string{"that", "this and/or"}},
+	}
+	for _, tt := range tests {
+		grid := NewTextGrid(0, 0)
+		if err := grid.LoadFrom(strings.NewReader(tt.text)); err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDiagram(grid)
+		if err != nil {
+			t.Errorf("%s: %v", tt.text, err)
+			continue
+		}
+		if len(d.G.Shapes) != 1 || !d.G.Shapes[0].Closed {
+			t.Errorf("%s: got shapes %+v, want one box", tt.text, d.G.Shapes)
+		}
+		labels := []string{}
+		for _, label := range d.G.Labels {
+			labels = append(labels, label.Text)
+		}
+		sort.Strings(labels)
+		if !reflect.DeepEqual(labels, tt.labels) {
+			t.Errorf("%s: got labels %q, want %q", tt.text, labels, tt.labels)
+		}
+		var buf strings.Builder
+		if err := WriteText(&d.G, &buf, nil); err != nil {
+			t.Errorf("%s: %v", tt.text, err)
+		} else if got := strings.TrimSuffix(buf.String(), "\n"); got != tt.text {
+			t.Errorf("rendered as text:\n%s\nwant:\n%s", got, tt.text)
+		}
+	}
+}
+
+func TestNewDiagramCrossing(t *testing.T) {
+	grid := NewTextGrid(0, 0)
+	err := grid.LoadFrom(strings.NewReader(`
++---+   +---+
+| A +-|-+ B |
++---+ | +---+
+      |`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDiagram(grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []graphical.Shape{}
+	for _, s := range d.G.Shapes {
+		if !s.Closed {
+			lines = append(lines, s)
+		}
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got lines %+v, want a horizontal one crossing a vertical one", lines)
+	}
+	for _, line := range lines {
+		if len(line.Points) != 2 {
+			t.Errorf("got line %v, want a straight one", line.Points)
+		}
+	}
+	if edges := ExtractGraph(&d.G).Edges; len(edges) != 1 {
+		t.Errorf("got edges %+v, want A-B", edges)
+	}
+}
+
+func TestNewDiagramCornerTags(t *testing.T) {
+	grid := NewTextGrid(0, 0)
+	err := grid.LoadFrom(strings.NewReader(`
++-------+  +-------+
+| round |  |{sharp}|
++-------+  +-------+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDiagramContext(context.Background(), grid, ProcessingOptions{AllCornersRound: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shapes := closedShapes(d)
+	if len(shapes) != 2 {
+		t.Fatalf("got shapes %+v, want two boxes", shapes)
+	}
+	for _, s := range shapes {
+		want := graphical.POINT_ROUND
+		if graphical.Bounds(s.Points).Min.X > float64(10*d.G.Grid.CellW) {
+			want = graphical.POINT_NORMAL
+		}
+		for _, p := range s.Points {
+			if p.Type != want {
+				t.Errorf("got corner %v, want type %v", p, want)
+			}
+		}
+	}
+}
+
+func TestNewDiagramArrowheadStyles(t *testing.T) {
+	text := `
++---+       +---+
+| A |------<| B |
++---+       +---+
+  |           |
+  o           ◆
+
++---+       +---+
+| C |<>-----| D |---->
++---+       +---+
+  |           |
+  #           v
+            +---+
+            | E |
+            +---+`
+	grid := NewTextGrid(0, 0)
+	err := grid.LoadFrom(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDiagramContext(context.Background(), grid, ProcessingOptions{Arrowheads: graphical.ARROWHEAD_OPEN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[graphical.ArrowheadStyle]int{}
+	for _, s := range d.G.Shapes {
+		if s.Type == graphical.TYPE_ARROWHEAD {
+			got[s.Arrowhead]++
+		}
+	}
+	want := map[graphical.ArrowheadStyle]int{
+		graphical.ARROWHEAD_CROWS_FOOT:     1,
+		graphical.ARROWHEAD_CIRCLE:         1,
+		graphical.ARROWHEAD_DIAMOND:        2,
+		graphical.ARROWHEAD_HOLLOW_DIAMOND: 1,
+		graphical.ARROWHEAD_OPEN:           2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got arrowheads %v, want %v", got, want)
+	}
+	buf := bytes.Buffer{}
+	err = WriteText(&d.G, &buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the diamonds are written in ASCII
+	wantText := strings.Replace(text, "◆", "#", 1) + "\n"
+	if got := buf.String(); got != wantText {
+		t.Errorf("rendered as:\n%s\nwant:\n%s", got, wantText)
+	}
+}
+
+func TestNewDiagramCrowsFeet(t *testing.T) {
+	tests := []struct {
+		text  string
+		crows int
+	}{
+		{`
++---+       +---+
+| A |------<| B |
++---+       +---+`, 1},
+		{`
++---+       +---+
+| A |>------| B |
++---+       +---+`, 1},
+		{`
++---+
+| A |
++---+
+  v
+  |
+  ^
++---+
+| B |
++---+`, 2},
+		// without a shape to touch, they are arrowheads or text
+		{`
++---+
+| A |------<
++---+`, 0},
+		{`
+  v       ^      |    |
+  |       |      v    ^
+  +-------+
+
+<-->  -->--  --<--  ^  v
+                    |  |
+                    v  ^`, 0},
+		{`
++--------------+
+| a<b, x > y   |
+| 2^n v1.2     |
+| <html> v ^   |
++--------------+`, 0},
+	}
+	for _, tt := range tests {
+		grid := NewTextGrid(0, 0)
+		if err := grid.LoadFrom(strings.NewReader(tt.text)); err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDiagram(grid)
+		if err != nil {
+			t.Errorf("%s: %v", tt.text, err)
+			continue
+		}
+		crows := 0
+		for _, s := range d.G.Shapes {
+			if s.Type == graphical.TYPE_ARROWHEAD && s.Arrowhead == graphical.ARROWHEAD_CROWS_FOOT {
+				crows++
+			}
+		}
+		if crows != tt.crows {
+			t.Errorf("%s: got %d crow's feet, want %d", tt.text, crows, tt.crows)
+		}
+	}
+}
+
+func TestNewDiagramCustomColors(t *testing.T) {
+	grid := NewTextGrid(0, 0)
+	err := grid.LoadFrom(strings.NewReader(`
++--------+ +-----------+ +-----------+ +--------+
+|cACM    | |c#1E90FF   | |c#1E90FF80 | |cDEF    |
++--------+ +-----------+ +-----------+ +--------+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	theme, err := graphical.DefaultTheme().WithColors(map[string]graphical.Color{
+		"ACM": {R: 0x12, G: 0x34, B: 0x56, A: 255},
+		"DEF": {R: 0x65, G: 0x43, B: 0x21, A: 255},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDiagramContext(context.Background(), grid, ProcessingOptions{Theme: theme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[graphical.Color]bool{}
+	for _, s := range d.G.Shapes {
+		if s.FillColor != nil {
+			got[*s.FillColor] = true
+		}
+	}
+	for _, want := range []graphical.Color{
+		{R: 0x12, G: 0x34, B: 0x56, A: 255},
+		{R: 0x1e, G: 0x90, B: 0xff, A: 255},
+		{R: 0x1e, G: 0x90, B: 0xff, A: 0x80},
+		// the name hides the hex code cDEF
+		{R: 0x65, G: 0x43, B: 0x21, A: 255},
+	} {
+		if !got[want] {
+			t.Errorf("no shape filled with %v, got %v", want, got)
+		}
+	}
+	for _, l := range d.G.Labels {
+		if strings.Contains(l.Text, "c") {
+			t.Errorf("color code left in label %q", l.Text)
+		}
+	}
+}
+
+func TestNewDiagramLineColors(t *testing.T) {
+	text := `
++-----+   cRED     +-----+
+|  A  |----------->|  B  |
++--+--+            +-----+
+   |cGRE              ^
+   |                  |
+   +------------------+
+
+  cBLU Failure path   Other text`
+	red := graphical.Color{R: 0xee, G: 0x33, B: 0x22, A: 255}
+	green := graphical.Color{R: 0x99, G: 0xdd, B: 0x99, A: 255}
+	blue := graphical.Color{R: 0x55, G: 0x55, B: 0xbb, A: 255}
+	for _, round := range []string{"text", "rendered text"} {
+		grid := NewTextGrid(0, 0)
+		err := grid.LoadFrom(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDiagram(grid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Diagnostics) > 0 {
+			t.Errorf("%s: got diagnostics %v", round, d.Diagnostics)
+		}
+		got := map[graphical.Color]int{}
+		for _, s := range d.G.Shapes {
+			if !s.Closed || s.Type == graphical.TYPE_ARROWHEAD {
+				got[s.StrokeColor]++
+			}
+		}
+		// a line and its arrowhead each
+		want := map[graphical.Color]int{red: 2, green: 2}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got lines and arrowheads %v, want %v", round, got, want)
+		}
+		labels := map[string]graphical.Color{}
+		for _, l := range d.G.Labels {
+			labels[l.Text] = l.Color
+		}
+		if labels["Failure path"] != blue || labels["Other text"] != (graphical.Color{A: 255}) {
+			t.Errorf("%s: got labels %v", round, labels)
+		}
+
+		buf := bytes.Buffer{}
+		err = WriteText(&d.G, &buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text = buf.String()
+	}
+}
+
+func TestNewDiagramTextBlocks(t *testing.T) {
+	grid := NewTextGrid(0, 0)
+	err := grid.LoadFrom(strings.NewReader(`
++------------------+ +------------------+ +------------------+
+| Left aligned     | |     Centered     | | {right}          |
+| lines            | |  lines of text   | |    Right aligned |
+|   and indented   | |      in a box    | |         by a tag |
++------------------+ +------------------+ +------------------+
+
+  {center} Text outside,
+  centered by a tag`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDiagram(grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Diagnostics) > 0 {
+		t.Errorf("got diagnostics %v", d.Diagnostics)
+	}
+	font := *fontmeasure.GetFontForHeight(baseFont, d.G.Grid.CellH)
+	// the left and right edges, and twice the center of each label
+	edges := map[string][3]int{}
+	for _, l := range d.G.Labels {
+		font.Size = l.FontSize
+		w := font.WidthFor(l.Text)
+		edges[l.Text] = [3]int{l.X, l.X + w, 2*l.X + w}
+	}
+	tests := []struct {
+		a, b string
+		edge int // which of the edges is the same
+		diff int // how far the second one is from the first one
+	}{
+		{"Left aligned", "lines", 0, 0},
+		{"Left aligned", "and indented", 0, 2 * d.G.Grid.CellW},
+		{"Centered", "lines of text", 2, 0},
+		{"Centered", "in a box", 2, 0},
+		{"Right aligned", "by a tag", 1, 0},
+		{"Text outside,", "centered by a tag", 2, 0},
+	}
+	for _, tt := range tests {
+		a, okA := edges[tt.a]
+		b, okB := edges[tt.b]
+		if !okA || !okB {
+			t.Errorf("no labels %q and %q in %v", tt.a, tt.b, edges)
+			continue
+		}
+		// the centers are rounded to whole pixels
+		if diff := b[tt.edge] - a[tt.edge] - tt.diff; diff < -1 || diff > 1 {
+			t.Errorf("%q and %q: got edges %v and %v", tt.a, tt.b, a, b)
+		}
+	}
+}
+
+// TestNewDiagramTextBlocksBug17 checks that the left aligned blocks of
+// bug17.txt with a short line in the middle are not taken for centered.
+func TestNewDiagramTextBlocksBug17(t *testing.T) {
+	d, err := NewDiagram(loadTestGrid(t, "bug17.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lefts := map[string][]int{}
+	for _, l := range d.G.Labels {
+		lefts[l.Text] = append(lefts[l.Text], l.X)
+	}
+	if len(lefts["World"]) != 2 {
+		t.Fatalf("got World labels at %v, want 2", lefts["World"])
+	}
+	// left aligned text starts at the left edge of its first cell; Server
+	// is also in other boxes, where it is alone and centered
+	for _, x := range lefts["World"] {
+		if x%d.G.Grid.CellW != 0 {
+			t.Errorf("World at %d, not at the edge of a cell", x)
+		}
+		found := false
+		for _, y := range lefts["Server"] {
+			found = found || x == y
+		}
+		if !found {
+			t.Errorf("World at %d, but Server at %v", x, lefts["Server"])
+		}
+	}
+}
+
+func benchmarkNewDiagram(b *testing.B, text string) {
+	grid := NewTextGrid(0, 0)
+	err := grid.LoadFrom(strings.NewReader(text))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := NewDiagram(grid)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewDiagramArt2(b *testing.B) {
+	buf, err := ioutil.ReadFile(filepath.Join(testTexts, "art2.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkNewDiagram(b, string(buf))
+}
+
+func BenchmarkNewDiagramDitaaBug(b *testing.B) {
+	buf, err := ioutil.ReadFile(filepath.Join(testTexts, "ditaa_bug.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkNewDiagram(b, string(buf))
+}
+
+// BenchmarkNewDiagramLarge interprets a 200x80 diagram of boxes and arrows.
+func BenchmarkNewDiagramLarge(b *testing.B) {
+	benchmarkNewDiagram(b, generateDiagram(rand.New(rand.NewSource(1)), 200/slotW, 80/slotH).Text)
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"math"
 
 	"github.com/akavel/ditaa/graphical"
 )
@@ -23,9 +23,14 @@ func NewSmallLine(grid *TextGrid, c Cell, gg graphical.Grid) *graphical.Shape {
 	return nil
 }
 
-func ConnectEndsToAnchors(s *graphical.Shape, grid *TextGrid, gg graphical.Grid) {
-	if s.Closed {
-		return
+// ConnectEndsToAnchors moves the ends of an open shape to the centers of
+// the neighbouring arrowheads, corners or intersections, of the lines it
+// crosses (see joinCrossingLines), or of any lines the ends of diagonal
+// lines point at. Ends that are already locked stay where they are. It
+// returns the cells of the ends that have nothing at all next to them.
+func ConnectEndsToAnchors(s *graphical.Shape, grid *TextGrid, gg graphical.Grid) (loose []Cell) {
+	if s.Closed || len(s.Points) < 2 {
+		return nil
 	}
 	n := len(s.Points)
 	// println(n)
@@ -33,29 +38,137 @@ func ConnectEndsToAnchors(s *graphical.Shape, grid *TextGrid, gg graphical.Grid)
 		{&s.Points[0], &s.Points[1]},
 		{&s.Points[n-1], &s.Points[n-2]},
 	} {
-		var x, y float64
-		switch {
-		case line.next.NorthOf(*line.end):
-			x, y = line.end.X, line.end.Y+float64(gg.CellH)
-		case line.next.SouthOf(*line.end):
-			x, y = line.end.X, line.end.Y-float64(gg.CellH)
-		case line.next.WestOf(*line.end):
-			x, y = line.end.X+float64(gg.CellW), line.end.Y
-		case line.next.EastOf(*line.end):
-			x, y = line.end.X-float64(gg.CellW), line.end.Y
-		}
-		anchor := gg.CellFor(graphical.Point{X: x, Y: y})
-		c := Cell(anchor)
-		if grid.IsArrowhead(c) || grid.IsCorner(c) || grid.IsIntersection(c) {
+		if line.end.Locked {
+			continue
+		}
+		step := Cell{compare(line.end.X, line.next.X), compare(line.end.Y, line.next.Y)}
+		if step == (Cell{}) {
+			continue
+		}
+		end := endCell(*line.end, *line.next, gg)
+		c := Cell{end.X + step.X, end.Y + step.Y}
+		diagonal := step.X != 0 && step.Y != 0
+		if grid.IsArrowhead(c) || grid.IsCorner(c) || grid.IsIntersection(c) ||
+			diagonal && grid.IsBoundary(c) || !diagonal && grid.isCrossing(c, step) {
+			anchor := graphical.Cell(c)
 			line.end.X, line.end.Y = gg.CellMidX(anchor), gg.CellMidY(anchor)
 			line.end.Locked = true
+			continue
+		}
+		if grid.IsBlankXY(c.X, c.Y) && !grid.IsCorner(end) && !grid.IsIntersection(end) && !grid.isJoinedSideways(end, step) {
+			loose = append(loose, end)
+		}
+	}
+	return loose
+}
+
+// isCrossing tells if a horizontal or vertical line going in the direction
+// step crosses another line at c and continues behind it, as in -|-.
+func (t *TextGrid) isCrossing(c, step Cell) bool {
+	behind := Cell{c.X + step.X, c.Y + step.Y}
+	if step.Y == 0 {
+		return t.IsVerticalLine(c) && t.IsHorizontalLine(behind)
+	}
+	return t.IsHorizontalLine(c) && t.IsVerticalLine(behind)
+}
+
+// joinCrossingLines joins the pairs of lines which ConnectEndsToAnchors
+// made meet in the middle of a line they cross, like the two halves of the
+// horizontal line in -|-, into single lines passing over it.
+func joinCrossingLines(shapes []graphical.Shape, grid *TextGrid, gg graphical.Grid) []graphical.Shape {
+	for i := 0; i < len(shapes); i++ {
+		for j := i + 1; j < len(shapes); j++ {
+			if joined := joinAtCrossing(&shapes[i], &shapes[j], grid, gg); joined != nil {
+				shapes[i] = *joined
+				shapes = append(shapes[:j], shapes[j+1:]...)
+				j = i
+			}
+		}
+	}
+	return shapes
+}
+
+func joinAtCrossing(a, b *graphical.Shape, grid *TextGrid, gg graphical.Grid) *graphical.Shape {
+	if a.Closed || b.Closed || len(a.Points) < 2 || len(b.Points) < 2 || a.Dashed != b.Dashed {
+		return nil
+	}
+	reversed := func(pp []graphical.Point) []graphical.Point {
+		r := make([]graphical.Point, len(pp))
+		for i, p := range pp {
+			r[len(pp)-1-i] = p
+		}
+		return r
+	}
+	for _, ap := range [][]graphical.Point{a.Points, reversed(a.Points)} {
+		for _, bp := range [][]graphical.Point{b.Points, reversed(b.Points)} {
+			n := len(ap)
+			end, prev, next := ap[n-1], ap[n-2], bp[1]
+			if !end.Locked || !bp[0].Locked || end.X != bp[0].X || end.Y != bp[0].Y {
+				continue
+			}
+			straight := prev.Y == end.Y && next.Y == end.Y || prev.X == end.X && next.X == end.X
+			step := Cell{compare(end.X, prev.X), compare(end.Y, prev.Y)}
+			if !straight || !grid.isCrossing(Cell(gg.CellFor(end)), step) {
+				continue
+			}
+			joined := *a
+			joined.Points = append(append([]graphical.Point{}, ap[:n-1]...), bp[1:]...)
+			return &joined
+		}
+	}
+	return nil
+}
+
+// isJoinedSideways tells if the end of a line at c, going in the direction
+// step, touches another line at its side: a diagonal line next to the end
+// of a horizontal or vertical one, or any line next to the end of a
+// diagonal one.
+func (t *TextGrid) isJoinedSideways(c, step Cell) bool {
+	if step.X != 0 && step.Y != 0 {
+		for _, side := range []Cell{{c.X + step.X, c.Y}, {c.X, c.Y + step.Y}} {
+			if t.IsBoundary(side) || t.IsDiagonalLine(side) {
+				return true
+			}
+		}
+		return false
+	}
+	return t.touchesDiagonalLine(c)
+}
+
+// touchesDiagonalLine tells if any of the eight neighbours of c is a part
+// of a diagonal line.
+func (t *TextGrid) touchesDiagonalLine(c Cell) bool {
+	for y := c.Y - 1; y <= c.Y+1; y++ {
+		for x := c.X - 1; x <= c.X+1; x++ {
+			if t.IsDiagonalLine(Cell{x, y}) {
+				return true
+			}
 		}
 	}
+	return false
+}
+
+// endCell returns the cell of the end of a line, as seen from the next
+// point of the line; the ends of diagonal lines lie on the corners of
+// their cells.
+func endCell(end, next graphical.Point, gg graphical.Grid) Cell {
+	return Cell(gg.CellFor(graphical.Point{X: end.X - float64(compare(end.X, next.X)), Y: end.Y - float64(compare(end.Y, next.Y))}))
+}
+
+// compare returns -1, 0 or 1 if a is less than, equal to or greater than b.
+func compare(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
 }
 
 func createOpenFromBoundaryCells(grid *TextGrid, cells *CellSet, gg graphical.Grid, allCornersRound bool) []graphical.Shape {
 	if cells.Type(grid) != SET_OPEN {
-		panic("CellSet is closed and cannot be handled by this method")
+		failf(cells.TopLeftCell(), "closed boundary cannot be made into lines")
 	}
 	if len(cells.Set) == 0 {
 		return []graphical.Shape{}
@@ -154,7 +267,7 @@ func makePointForCell(c Cell, grid *TextGrid, gg graphical.Grid, allCornersRound
 	case grid.IsLinesEnd(c) || grid.IsIntersection(c):
 		typ = graphical.POINT_NORMAL
 	default:
-		panic(fmt.Sprint("Cannot make point for cell", c))
+		failf(c, "ambiguous input: cannot make a point for cell %q", grid.GetCell(c))
 	}
 	return graphical.Point{
 		X:    gg.CellMidX(graphical.Cell(c)),
@@ -163,8 +276,28 @@ func makePointForCell(c Cell, grid *TextGrid, gg graphical.Grid, allCornersRound
 	}
 }
 
-func createArrowhead(grid *TextGrid, c Cell, gg graphical.Grid) *graphical.Shape {
-	if !grid.IsArrowhead(c) {
+// setCorners sets the type of the points of the shape where its sides
+// turn to typ.
+func setCorners(s *graphical.Shape, typ graphical.PointType) {
+	n := len(s.Points)
+	for i := range s.Points {
+		if !s.Closed && (i == 0 || i == n-1) {
+			continue
+		}
+		prev, p, next := s.Points[(i+n-1)%n], s.Points[i], s.Points[(i+1)%n]
+		straight := prev.X == p.X && p.X == next.X || prev.Y == p.Y && p.Y == next.Y
+		if !straight {
+			s.Points[i].Type = typ
+		}
+	}
+}
+
+// createArrowhead makes the shape of the arrowhead at c, drawing the plain
+// arrowheads in the given style. It returns nil for the second cell of <>,
+// which is a part of the arrowhead in the first one.
+func createArrowhead(grid *TextGrid, c Cell, gg graphical.Grid, style graphical.ArrowheadStyle) *graphical.Shape {
+	a, ok := grid.findArrowhead(c)
+	if !ok || a.Cells == 0 {
 		return nil
 	}
 
@@ -174,35 +307,61 @@ func createArrowhead(grid *TextGrid, c Cell, gg graphical.Grid) *graphical.Shape
 		FillColor:   &BLACK,
 		StrokeColor: BLACK,
 		Type:        graphical.TYPE_ARROWHEAD,
+		Arrowhead:   a.Style,
 	}
-	cc := graphical.Cell(c)
-	switch {
-	case grid.IsNorthArrowhead(c):
+	if a.Plain {
+		s.Arrowhead = style
+	}
+	// the cells it spans
+	lo := graphical.Cell(c)
+	hi := graphical.Cell{X: c.X + (a.Cells-1)*a.Dir.X, Y: c.Y + (a.Cells-1)*a.Dir.Y}
+	if hi.X < lo.X || hi.Y < lo.Y {
+		lo, hi = hi, lo
+	}
+	switch a.Dir {
+	case Cell{0, -1}:
 		s.Points = []graphical.Point{
-			{X: gg.CellMidX(cc), Y: gg.CellMinY(cc)},
-			{X: gg.CellMinX(cc), Y: gg.CellMaxY(cc)},
-			{X: gg.CellMaxX(cc), Y: gg.CellMaxY(cc)},
+			{X: gg.CellMidX(lo), Y: gg.CellMinY(lo)},
+			{X: gg.CellMinX(lo), Y: gg.CellMaxY(hi)},
+			{X: gg.CellMaxX(hi), Y: gg.CellMaxY(hi)},
 		}
-	case grid.IsSouthArrowhead(c):
+	case Cell{0, 1}:
 		s.Points = []graphical.Point{
-			{X: gg.CellMinX(cc), Y: gg.CellMinY(cc)},
-			{X: gg.CellMidX(cc), Y: gg.CellMaxY(cc)},
-			{X: gg.CellMaxX(cc), Y: gg.CellMinY(cc)},
+			{X: gg.CellMinX(lo), Y: gg.CellMinY(lo)},
+			{X: gg.CellMidX(lo), Y: gg.CellMaxY(hi)},
+			{X: gg.CellMaxX(hi), Y: gg.CellMinY(lo)},
 		}
-	case grid.IsWestArrowhead(c):
+	case Cell{-1, 0}:
 		s.Points = []graphical.Point{
-			{X: gg.CellMaxX(cc), Y: gg.CellMinY(cc)},
-			{X: gg.CellMinX(cc), Y: gg.CellMidY(cc)},
-			{X: gg.CellMaxX(cc), Y: gg.CellMaxY(cc)},
+			{X: gg.CellMaxX(hi), Y: gg.CellMinY(lo)},
+			{X: gg.CellMinX(lo), Y: gg.CellMidY(lo)},
+			{X: gg.CellMaxX(hi), Y: gg.CellMaxY(hi)},
 		}
-	case grid.IsEastArrowhead(c):
+	case Cell{1, 0}:
 		s.Points = []graphical.Point{
-			{X: gg.CellMinX(cc), Y: gg.CellMinY(cc)},
-			{X: gg.CellMaxX(cc), Y: gg.CellMidY(cc)},
-			{X: gg.CellMinX(cc), Y: gg.CellMaxY(cc)},
+			{X: gg.CellMinX(lo), Y: gg.CellMinY(lo)},
+			{X: gg.CellMaxX(hi), Y: gg.CellMidY(lo)},
+			{X: gg.CellMinX(lo), Y: gg.CellMaxY(hi)},
 		}
 	default:
-		return nil
+		s.Points = diagonalArrowheadPoints(a.Dir, graphical.Cell(c), gg)
 	}
 	return &s
 }
+
+// diagonalArrowheadPoints returns a triangle with its tip in the corner of
+// the cell in the direction dir, as wide as the cell.
+func diagonalArrowheadPoints(dir Cell, cc graphical.Cell, gg graphical.Grid) []graphical.Point {
+	cx, cy := gg.CellMidX(cc), gg.CellMidY(cc)
+	// from the center to the tip
+	vx, vy := float64(dir.X*gg.CellW)/2, float64(dir.Y*gg.CellH)/2
+	// from the middle of the base to its corners
+	l := math.Hypot(vx, vy)
+	px, py := -vy/l*gg.MinimumOfCellDimensions()/2, vx/l*gg.MinimumOfCellDimensions()/2
+	bx, by := cx-0.4*vx, cy-0.4*vy
+	return []graphical.Point{
+		{X: cx + vx, Y: cy + vy},
+		{X: bx + px, Y: by + py},
+		{X: bx - px, Y: by - py},
+	}
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"image"
 	"image/png"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/akavel/ditaa/graphical"
 )
@@ -16,41 +22,144 @@ const (
 	CELL_HEIGHT      = 14
 )
 
+// outputFormats maps the names accepted by the -format flag to functions
+// writing the recognized diagram in that format.
+var outputFormats = map[string]func(ctx context.Context, d *graphical.Diagram, w io.Writer) error{
+	"png":  writePNG,
+	"json": noContext((*graphical.Diagram).WriteJSON),
+	"xml":  noContext((*graphical.Diagram).WriteXML),
+	"graph": noContext(func(d *graphical.Diagram, w io.Writer) error {
+		return ExtractGraph(d).WriteJSON(w)
+	}),
+	"dot": noContext(func(d *graphical.Diagram, w io.Writer) error {
+		return ExtractGraph(d).WriteDOT(w)
+	}),
+	"mermaid": noContext(func(d *graphical.Diagram, w io.Writer) error {
+		return ExtractGraph(d).WriteMermaid(w)
+	}),
+	"txt": noContext(func(d *graphical.Diagram, w io.Writer) error {
+		return WriteText(d, w, renderOptions.Theme)
+	}),
+	"drawio":     noContext(WriteDrawio),
+	"excalidraw": noContext(WriteExcalidraw),
+	"tikz": noContext(func(d *graphical.Diagram, w io.Writer) error {
+		return d.WriteTikZ(w, renderOptions, false)
+	}),
+	"tex": noContext(func(d *graphical.Diagram, w io.Writer) error {
+		return d.WriteTikZ(w, renderOptions, true)
+	}),
+}
+
+// noContext adapts the output functions which take time proportional to
+// the size of the diagram, and so need no cancellation.
+func noContext(write func(d *graphical.Diagram, w io.Writer) error) func(context.Context, *graphical.Diagram, io.Writer) error {
+	return func(ctx context.Context, d *graphical.Diagram, w io.Writer) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return write(d, w)
+	}
+}
+
+// inputFormats maps the names accepted by the -input flag to functions
+// reading an already recognized diagram, bypassing the text analysis.
+var inputFormats = map[string]func(r io.Reader) (*graphical.Diagram, error){
+	"json": graphical.ReadJSON,
+	"xml":  graphical.ReadXML,
+}
+
+var (
+	format = flag.String("format", "png", "output format, one of: "+strings.Join(formatNames(), ", "))
+	input  = flag.String("input", "", "input format: txt, json or xml (default: guessed from INFILE extension)")
+
+	theme      = flag.String("theme", "classic", "colors and sizes to draw with: the name of one of "+strings.Join(graphical.ThemeNames(), ", ")+", or a theme file")
+	arrowheads = flag.String("arrowheads", "filled", "style of the arrowheads drawn with ^, v, < and >, one of: "+strings.Join(graphical.ArrowheadStyleNames(), ", "))
+	colors     = namedColors{}
+	timeout    = flag.Duration("timeout", 0, "give up if the conversion takes longer than this (0 means no limit)")
+	processing ProcessingOptions
+
+	renderOptions = graphical.Options{DropShadows: true}
+)
+
+func init() {
+	flag.IntVar(&processing.MaxWidth, "max-width", 0, "maximum width of the diagram, in characters (0 means no limit)")
+	flag.IntVar(&processing.MaxHeight, "max-height", 0, "maximum height of the diagram, in lines (0 means no limit)")
+	flag.IntVar(&processing.MaxShapes, "max-shapes", 0, "maximum number of shapes in the diagram (0 means no limit)")
+	flag.IntVar(&processing.MaxLabels, "max-labels", 0, "maximum number of labels in the diagram (0 means no limit)")
+	flag.Var(colors, "color", "add a named color code, as NAME=#RRGGBB or NAME=#RRGGBBAA, e.g. ACM=#1E90FF for cACM (may be repeated)")
+	flag.BoolVar(&processing.AllCornersRound, "round-corners", false, "make all the corners of shapes round, except in shapes tagged {sharp}")
+	flag.Float64Var(&renderOptions.CornerRadius, "corner-radius", 0, "radius of round corners, in pixels (0 means rounding off the corner cells)")
+	flag.BoolVar(&renderOptions.FixedSlope, "fixed-slope", false, "make the sides of IO and trapezoid shapes slope at a fixed angle, instead of by a fixed width")
+	flag.BoolVar(&renderOptions.LineHops, "line-hops", false, "draw a hop in horizontal lines where they cross vertical ones without a '+'")
+}
+
+func formatNames() []string {
+	names := []string{}
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func main() {
-	if len(os.Args[1:]) != 2 {
-		fmt.Fprintf(os.Stderr, "USAGE: %s INFILE OUTFILE.png\n", os.Args[0])
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		os.Exit(lintMain(os.Args[2:], os.Stdout))
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: %s [FLAGS] INFILE OUTFILE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s lint [FLAGS] FILE...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	err := run(os.Args[1], os.Args[2])
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	err := run(ctx, flag.Arg(0), flag.Arg(1))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		os.Exit(2)
 	}
 }
 
-func run(infile, outfile string) error {
-	r, err := os.Open(infile)
+func run(ctx context.Context, infile, outfile string) error {
+	write, ok := outputFormats[*format]
+	if !ok {
+		return fmt.Errorf("unknown output format '%s'", *format)
+	}
+	style, ok := graphical.ParseArrowheadStyle(*arrowheads)
+	if !ok {
+		return fmt.Errorf("unknown arrowhead style '%s'", *arrowheads)
+	}
+	processing.Arrowheads = style
+	t, err := loadTheme(*theme)
 	if err != nil {
 		return err
 	}
-	grid := NewTextGrid(0, 0)
-	err = grid.LoadFrom(r)
+	t, err = t.WithColors(colors)
 	if err != nil {
 		return err
 	}
-	if DEBUG {
-		fmt.Println("Using grid:")
-		fmt.Print(grid.DEBUG())
-		//fmt.Print(grid.DEBUG()) // why this gets printed twice in Java code?
-	}
-	diagram := NewDiagram(grid)
+	processing.Theme, renderOptions.Theme = t, t
 
-	img := image.NewRGBA(image.Rect(0, 0, diagram.G.Grid.W, diagram.G.Grid.H))
-	err = graphical.RenderDiagram(img, &diagram.G, graphical.Options{DropShadows: true}, baseFont)
+	r, err := os.Open(infile)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
+	diagram, err := load(ctx, infile, bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+
 	w, err := os.Create(outfile)
 	if err != nil {
 		return err
@@ -58,10 +167,107 @@ func run(infile, outfile string) error {
 	defer w.Close()
 
 	wbuf := bufio.NewWriter(w)
-	err = png.Encode(wbuf, img)
+	err = write(ctx, diagram, wbuf)
 	if err != nil {
 		return err
 	}
 	err = wbuf.Flush()
 	return err
 }
+
+// namedColors are the colors added to the palette of the theme with the
+// -color flags.
+type namedColors map[string]graphical.Color
+
+func (n namedColors) String() string {
+	names := []string{}
+	for name, c := range n {
+		names = append(names, fmt.Sprintf("%s=#%02X%02X%02X%02X", name, c.R, c.G, c.B, c.A))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (n namedColors) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return fmt.Errorf("want NAME=#RRGGBB")
+	}
+	c, err := graphical.ParseColor(s[i+1:])
+	if err != nil {
+		return err
+	}
+	n[s[:i]] = c
+	return nil
+}
+
+// loadTheme returns the built-in theme with the name, or else the theme
+// read from the file with the name.
+func loadTheme(name string) (*graphical.Theme, error) {
+	if t, ok := graphical.Themes[name]; ok {
+		return t, nil
+	}
+	r, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown theme '%s': %s", name, err)
+	}
+	defer r.Close()
+	t, err := graphical.LoadTheme(r)
+	if err != nil {
+		return nil, fmt.Errorf("loading theme from '%s': %s", name, err)
+	}
+	return t, nil
+}
+
+func writePNG(ctx context.Context, diagram *graphical.Diagram, w io.Writer) error {
+	err := checkImageSize(diagram.Grid)
+	if err != nil {
+		return err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, diagram.Grid.W, diagram.Grid.H))
+	err = graphical.RenderDiagramContext(ctx, img, diagram, renderOptions, baseFont)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+func load(ctx context.Context, infile string, r io.Reader) (*graphical.Diagram, error) {
+	inputFormat := *input
+	if inputFormat == "" {
+		inputFormat = strings.TrimPrefix(strings.ToLower(filepath.Ext(infile)), ".")
+	}
+	if read, ok := inputFormats[inputFormat]; ok {
+		diagram, err := read(r)
+		if err != nil {
+			return nil, fmt.Errorf("decoding diagram from '%s': %s", infile, err)
+		}
+		err = processing.CheckDiagram(diagram)
+		if err != nil {
+			return nil, fmt.Errorf("loading diagram from '%s': %s", infile, err)
+		}
+		return diagram, nil
+	}
+	if *input != "" && *input != "txt" {
+		return nil, fmt.Errorf("unknown input format '%s'", *input)
+	}
+
+	grid := NewTextGrid(0, 0)
+	err := grid.LoadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	if DEBUG {
+		fmt.Println("Using grid:")
+		fmt.Print(grid.DEBUG())
+		//fmt.Print(grid.DEBUG()) // why this gets printed twice in Java code?
+	}
+	diagram, err := NewDiagramContext(ctx, grid, processing)
+	if err != nil {
+		return nil, fmt.Errorf("interpreting diagram from '%s': %s", infile, err)
+	}
+	for _, diag := range diagram.Diagnostics {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", infile, diag)
+	}
+	return &diagram.G, nil
+}
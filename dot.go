@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akavel/ditaa/graphical"
+)
+
+// dotShapes maps the types of nodes to the closest Graphviz node shapes.
+var dotShapes = map[graphical.ShapeType]string{
+	graphical.TYPE_SIMPLE:           "box",
+	graphical.TYPE_DOCUMENT:         "note",
+	graphical.TYPE_STORAGE:          "cylinder",
+	graphical.TYPE_IO:               "parallelogram",
+	graphical.TYPE_DECISION:         "diamond",
+	graphical.TYPE_MANUAL_OPERATION: "invtrapezium",
+	graphical.TYPE_TRAPEZOID:        "trapezium",
+	graphical.TYPE_ELLIPSE:          "ellipse",
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language, keeping the
+// labels, fill colors and shape types of the nodes, and the directions
+// and dashing of the edges. Layout is left to Graphviz.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph ditaa {")
+	for _, n := range g.Nodes {
+		attrs := []string{"label=" + dotQuote(n.Name)}
+		shape, ok := dotShapes[n.Shape.Type]
+		if !ok {
+			shape = "box"
+		}
+		attrs = append(attrs, "shape="+shape)
+		styles := []string{}
+		if n.FillColor != nil {
+			styles = append(styles, "filled")
+			attrs = append(attrs, "fillcolor="+dotQuote(n.FillColor.Hex()))
+			if IsDark(*n.FillColor) {
+				attrs = append(attrs, "fontcolor="+dotQuote(graphical.WHITE.Hex()))
+			}
+		}
+		if n.Dashed {
+			styles = append(styles, "dashed")
+		}
+		if len(styles) > 0 {
+			attrs = append(attrs, "style="+dotQuote(strings.Join(styles, ",")))
+		}
+		fmt.Fprintf(bw, "\t%s [%s];\n", n.ID, strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges {
+		attrs := []string{}
+		if !e.Directed {
+			attrs = append(attrs, "dir=none")
+		}
+		if e.Dashed {
+			attrs = append(attrs, "style=dashed")
+		}
+		if len(attrs) == 0 {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(bw, "\t%s -> %s [%s];\n", e.From, e.To, strings.Join(attrs, ", "))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/akavel/ditaa/graphical"
+)
+
+// exportTestText is the source of the diagram the outputs of the
+// exporters are compared against.
+const exportTestText = `
++-------+    +--------+
+|{s}    |    |cBLU    |
+| "db"  +--->| B      |
++---+---+    +---+----+
+    |            :
+    |            :
+    |            v
+    |        +--------+
+    +--------+{d}     |
+             | doc    |
+             +--------+`
+
+func exportTestDiagram(t *testing.T) *graphical.Diagram {
+	grid := NewTextGrid(0, 0)
+	grid.LoadFrom(strings.NewReader(exportTestText))
+	d, err := NewDiagram(grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &d.G
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	err := ExtractGraph(exportTestDiagram(t)).WriteDOT(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph ditaa {
+	n1 [label="\"db\"", shape=cylinder];
+	n2 [label="B", shape=box, fillcolor="#5555bb", fontcolor="#ffffff", style="filled"];
+	n3 [label="doc", shape=note];
+	n1 -> n2;
+	n1 -> n3 [dir=none];
+	n2 -> n3 [style=dashed];
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
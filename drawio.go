@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akavel/ditaa/fontmeasure"
+	"github.com/akavel/ditaa/graphical"
+)
+
+// drawioStyles maps the types of shapes to draw.io vertex styles.
+var drawioStyles = map[graphical.ShapeType]string{
+	graphical.TYPE_SIMPLE:           "rounded=0;whiteSpace=wrap;",
+	graphical.TYPE_DOCUMENT:         "shape=document;whiteSpace=wrap;boundedLbl=1;",
+	graphical.TYPE_STORAGE:          "shape=cylinder3;whiteSpace=wrap;boundedLbl=1;backgroundOutline=1;size=7;",
+	graphical.TYPE_IO:               "shape=parallelogram;perimeter=parallelogramPerimeter;whiteSpace=wrap;fixedSize=1;size=5;",
+	graphical.TYPE_DECISION:         "rhombus;whiteSpace=wrap;",
+	graphical.TYPE_MANUAL_OPERATION: "shape=trapezoid;perimeter=trapezoidPerimeter;whiteSpace=wrap;fixedSize=1;size=5;flipV=1;",
+	graphical.TYPE_TRAPEZOID:        "shape=trapezoid;perimeter=trapezoidPerimeter;whiteSpace=wrap;fixedSize=1;size=5;",
+	graphical.TYPE_ELLIPSE:          "ellipse;whiteSpace=wrap;",
+	graphical.TYPE_POINT_MARKER:     "ellipse;aspect=fixed;fillColor=#ffffff;",
+}
+
+// drawioArrows maps the styles of arrowheads to draw.io arrows.
+var drawioArrows = map[graphical.ArrowheadStyle]struct {
+	name string
+	fill int
+}{
+	graphical.ARROWHEAD_FILLED:         {"block", 1},
+	graphical.ARROWHEAD_OPEN:           {"open", 0},
+	graphical.ARROWHEAD_DIAMOND:        {"diamond", 1},
+	graphical.ARROWHEAD_HOLLOW_DIAMOND: {"diamond", 0},
+	graphical.ARROWHEAD_CIRCLE:         {"oval", 0},
+	graphical.ARROWHEAD_CROWS_FOOT:     {"ERmany", 0},
+}
+
+type mxFile struct {
+	XMLName xml.Name `xml:"mxfile"`
+	Host    string   `xml:"host,attr"`
+	Diagram struct {
+		ID    string `xml:"id,attr"`
+		Name  string `xml:"name,attr"`
+		Model struct {
+			Grid     int      `xml:"grid,attr"`
+			GridSize int      `xml:"gridSize,attr"`
+			PageW    int      `xml:"pageWidth,attr"`
+			PageH    int      `xml:"pageHeight,attr"`
+			Cells    []mxCell `xml:"root>mxCell"`
+		} `xml:"mxGraphModel"`
+	} `xml:"diagram"`
+}
+
+type mxCell struct {
+	ID       string      `xml:"id,attr"`
+	Value    string      `xml:"value,attr,omitempty"`
+	Style    string      `xml:"style,attr,omitempty"`
+	Vertex   string      `xml:"vertex,attr,omitempty"`
+	Edge     string      `xml:"edge,attr,omitempty"`
+	Parent   string      `xml:"parent,attr,omitempty"`
+	Source   string      `xml:"source,attr,omitempty"`
+	Target   string      `xml:"target,attr,omitempty"`
+	Geometry *mxGeometry `xml:"mxGeometry"`
+}
+
+type mxGeometry struct {
+	X         float64   `xml:"x,attr,omitempty"`
+	Y         float64   `xml:"y,attr,omitempty"`
+	W         float64   `xml:"width,attr,omitempty"`
+	H         float64   `xml:"height,attr,omitempty"`
+	Relative  string    `xml:"relative,attr,omitempty"`
+	As        string    `xml:"as,attr"`
+	Ends      []mxPoint `xml:"mxPoint"`
+	Waypoints *mxArray  `xml:"Array"`
+}
+
+type mxArray struct {
+	As     string    `xml:"as,attr"`
+	Points []mxPoint `xml:"mxPoint"`
+}
+
+type mxPoint struct {
+	X  float64 `xml:"x,attr"`
+	Y  float64 `xml:"y,attr"`
+	As string  `xml:"as,attr,omitempty"`
+}
+
+/*
+WriteDrawio writes the diagram as a draw.io (diagrams.net) mxGraph file:
+
+  - closed shapes become vertices, with geometry from their bounds and
+    style from their type, fill color and dashing; shapes which are not
+    rectangular are approximated by their bounds,
+  - labels become the values of the smallest vertices containing them
+    (as found by FindSmallestShapeContaining); the other labels become
+    free text vertices,
+  - open shapes become edges with waypoints from their points; free ends
+    of lines are connected to the vertices next to them (see ExtractGraph)
+    and arrowheads become edge arrows,
+  - point markers become small circles.
+*/
+func WriteDrawio(d *graphical.Diagram, w io.Writer) error {
+	g := ExtractGraph(d)
+	f := mxFile{Host: "ditaa"}
+	f.Diagram.ID = "ditaa"
+	f.Diagram.Name = "Page-1"
+	model := &f.Diagram.Model
+	model.Grid, model.GridSize = 1, d.Grid.CellW
+	model.PageW, model.PageH = d.Grid.W, d.Grid.H
+	model.Cells = []mxCell{{ID: "0"}, {ID: "1", Parent: "0"}}
+
+	named := map[graphical.Label]bool{}
+	for _, n := range g.Nodes {
+		for _, label := range n.Labels {
+			named[label] = true
+		}
+		style := drawioStyles[n.Shape.Type]
+		if style == "" {
+			style = drawioStyles[graphical.TYPE_SIMPLE]
+		}
+		if n.Shape.Type == graphical.TYPE_SIMPLE && hasRoundCorners(n.Shape) {
+			style = strings.Replace(style, "rounded=0", "rounded=1", 1)
+		}
+		style += drawioColors(n.FillColor, n.Shape.StrokeColor)
+		if n.Dashed {
+			style += "dashed=1;"
+		}
+		bb := graphical.Bounds(n.Shape.Points)
+		model.Cells = append(model.Cells, mxCell{
+			ID:     n.ID,
+			Value:  joinLabels(n.Labels, "\n"),
+			Style:  style,
+			Vertex: "1",
+			Parent: "1",
+			Geometry: &mxGeometry{
+				X: bb.Min.X, Y: bb.Min.Y,
+				W: bb.Max.X - bb.Min.X, H: bb.Max.Y - bb.Min.Y,
+				As: "geometry",
+			},
+		})
+	}
+
+	for i := range d.Shapes {
+		shape := &d.Shapes[i]
+		if shape.Type != graphical.TYPE_POINT_MARKER || len(shape.Points) != 1 {
+			continue
+		}
+		size := 0.7 * d.Grid.MinimumOfCellDimensions()
+		p := shape.Points[0]
+		model.Cells = append(model.Cells, mxCell{
+			ID:     fmt.Sprintf("m%d", i+1),
+			Style:  drawioStyles[graphical.TYPE_POINT_MARKER] + "strokeColor=" + shape.StrokeColor.Hex() + ";",
+			Vertex: "1",
+			Parent: "1",
+			Geometry: &mxGeometry{
+				X: p.X - size/2, Y: p.Y - size/2, W: size, H: size,
+				As: "geometry",
+			},
+		})
+	}
+
+	lines, ends := connectLines(d, g)
+	for i, line := range lines {
+		cell := mxCell{
+			ID:       fmt.Sprintf("e%d", i+1),
+			Edge:     "1",
+			Parent:   "1",
+			Geometry: &mxGeometry{Relative: "1", As: "geometry"},
+		}
+		style := "rounded=0;html=0;" + "strokeColor=" + line.StrokeColor.Hex() + ";"
+		if line.Dashed {
+			style += "dashed=1;"
+		}
+		for j, end := range ends[i] {
+			arrow, fill, as, vertex := "endArrow", "endFill", "targetPoint", &cell.Target
+			if j == 0 {
+				arrow, fill, as, vertex = "startArrow", "startFill", "sourcePoint", &cell.Source
+			}
+			if end.Arrowhead {
+				a := drawioArrows[end.Style]
+				style += fmt.Sprintf("%s=%s;%s=%d;", arrow, a.name, fill, a.fill)
+			} else {
+				style += arrow + "=none;"
+			}
+			if end.Node != nil {
+				*vertex = end.Node.ID
+			}
+			cell.Geometry.Ends = append(cell.Geometry.Ends, mxPoint{X: end.Point.X, Y: end.Point.Y, As: as})
+		}
+		if n := len(line.Points); n > 2 {
+			cell.Geometry.Waypoints = &mxArray{As: "points"}
+			for _, p := range line.Points[1 : n-1] {
+				cell.Geometry.Waypoints.Points = append(cell.Geometry.Waypoints.Points, mxPoint{X: p.X, Y: p.Y})
+			}
+		}
+		cell.Style = style
+		model.Cells = append(model.Cells, cell)
+	}
+
+	// labels not inside any vertex
+	font := &fontmeasure.Font{Font: baseFont, DPI: 72}
+	for i, label := range d.Labels {
+		if named[label] {
+			continue
+		}
+		bb := label.BoundsFor(font)
+		model.Cells = append(model.Cells, mxCell{
+			ID:     fmt.Sprintf("t%d", i+1),
+			Value:  label.Text,
+			Style:  fmt.Sprintf("text;html=0;align=left;verticalAlign=middle;spacing=0;fontSize=%g;fontColor=%s;", label.FontSize, label.Color.Hex()),
+			Vertex: "1",
+			Parent: "1",
+			Geometry: &mxGeometry{
+				X: bb.Min.X, Y: bb.Min.Y,
+				W: bb.Max.X - bb.Min.X, H: bb.Max.Y - bb.Min.Y,
+				As: "geometry",
+			},
+		})
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(&f)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func drawioColors(fill *graphical.Color, stroke graphical.Color) string {
+	style := "strokeColor=" + stroke.Hex() + ";"
+	if fill == nil {
+		return style + "fillColor=#ffffff;"
+	}
+	style += "fillColor=" + fill.Hex() + ";"
+	if fill.A != 255 {
+		style += fmt.Sprintf("fillOpacity=%d;", int(fill.A)*100/255)
+	}
+	if IsDark(*fill) {
+		style += "fontColor=" + graphical.WHITE.Hex() + ";"
+	}
+	return style
+}
+
+func hasRoundCorners(s *graphical.Shape) bool {
+	for _, p := range s.Points {
+		if p.Type == graphical.POINT_ROUND {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/akavel/ditaa/graphical"
+)
+
+// exportTestShapes returns a diagram built shape by shape, for the
+// exporters which keep the order of the shapes: a box and a storage
+// connected by an arrow, a dashed line dangling from the box, a point
+// marker and a free label.
+func exportTestShapes() *graphical.Diagram {
+	pt := func(x, y float64) graphical.Point { return graphical.Point{X: x, Y: y} }
+	box := graphical.NewShape(pt(5, 7), pt(45, 7), pt(45, 35), pt(5, 35))
+	box.Closed = true
+	storage := graphical.NewShape(pt(95, 7), pt(135, 7), pt(135, 35), pt(95, 35))
+	storage.Type = graphical.TYPE_STORAGE
+	storage.Closed = true
+	storage.FillColor = &graphical.Color{R: 0x55, G: 0x55, B: 0xbb, A: 255}
+	arrow := graphical.NewShape(pt(45, 21), pt(90, 21))
+	head := graphical.NewShape(pt(85, 14), pt(95, 21), pt(85, 28))
+	head.Type = graphical.TYPE_ARROWHEAD
+	head.Closed = true
+	dangling := graphical.NewShape(pt(25, 35), pt(25, 63), pt(65, 63))
+	dangling.Dashed = true
+	marker := graphical.NewShape(pt(65, 63))
+	marker.Type = graphical.TYPE_POINT_MARKER
+	return &graphical.Diagram{
+		Grid:   graphical.Grid{W: 150, H: 84, CellW: 10, CellH: 14},
+		Shapes: []graphical.Shape{*box, *storage, *arrow, *head, *dangling, *marker},
+		Labels: []graphical.Label{
+			{Text: "A", FontSize: 12, X: 15, Y: 25, Color: graphical.Color{A: 255}},
+			{Text: "<B&>", FontSize: 12, X: 105, Y: 25, Color: graphical.WHITE},
+			{Text: "note", FontSize: 12, X: 75, Y: 67, Color: graphical.Color{A: 255}},
+		},
+	}
+}
+
+func TestWriteDrawio(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteDrawio(exportTestShapes(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<mxfile host="ditaa">
+  <diagram id="ditaa" name="Page-1">
+    <mxGraphModel grid="1" gridSize="10" pageWidth="150" pageHeight="84">
+      <root>
+        <mxCell id="0"></mxCell>
+        <mxCell id="1" parent="0"></mxCell>
+        <mxCell id="n1" value="A" style="rounded=0;whiteSpace=wrap;strokeColor=#000000;fillColor=#ffffff;" vertex="1" parent="1">
+          <mxGeometry x="5" y="7" width="40" height="28" as="geometry"></mxGeometry>
+        </mxCell>
+        <mxCell id="n2" value="&lt;B&amp;&gt;" style="shape=cylinder3;whiteSpace=wrap;boundedLbl=1;backgroundOutline=1;size=7;strokeColor=#000000;fillColor=#5555bb;fontColor=#ffffff;" vertex="1" parent="1">
+          <mxGeometry x="95" y="7" width="40" height="28" as="geometry"></mxGeometry>
+        </mxCell>
+        <mxCell id="m6" style="ellipse;aspect=fixed;fillColor=#ffffff;strokeColor=#000000;" vertex="1" parent="1">
+          <mxGeometry x="61.5" y="59.5" width="7" height="7" as="geometry"></mxGeometry>
+        </mxCell>
+        <mxCell id="e1" style="rounded=0;html=0;strokeColor=#000000;startArrow=none;endArrow=block;endFill=1;" edge="1" parent="1" source="n1" target="n2">
+          <mxGeometry relative="1" as="geometry">
+            <mxPoint x="45" y="21" as="sourcePoint"></mxPoint>
+            <mxPoint x="95" y="21" as="targetPoint"></mxPoint>
+          </mxGeometry>
+        </mxCell>
+        <mxCell id="e2" style="rounded=0;html=0;strokeColor=#000000;dashed=1;startArrow=none;endArrow=none;" edge="1" parent="1" source="n1">
+          <mxGeometry relative="1" as="geometry">
+            <mxPoint x="25" y="35" as="sourcePoint"></mxPoint>
+            <mxPoint x="65" y="63" as="targetPoint"></mxPoint>
+            <Array as="points">
+              <mxPoint x="25" y="63"></mxPoint>
+            </Array>
+          </mxGeometry>
+        </mxCell>
+        <mxCell id="t3" value="note" style="text;html=0;align=left;verticalAlign=middle;spacing=0;fontSize=12;fontColor=#000000;" vertex="1" parent="1">
+          <mxGeometry x="75" y="57" width="23" height="13" as="geometry"></mxGeometry>
+        </mxCell>
+      </root>
+    </mxGraphModel>
+  </diagram>
+</mxfile>
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteDrawioIsValid(t *testing.T) {
+	for _, d := range []*graphical.Diagram{exportTestShapes(), exportTestDiagram(t)} {
+		var buf bytes.Buffer
+		err := WriteDrawio(d, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := mxFile{}
+		err = xml.Unmarshal(buf.Bytes(), &f)
+		if err != nil {
+			t.Errorf("%v in:\n%s", err, buf.String())
+			continue
+		}
+		ids := map[string]bool{}
+		for _, c := range f.Diagram.Model.Cells {
+			if ids[c.ID] {
+				t.Errorf("duplicate cell id %q", c.ID)
+			}
+			ids[c.ID] = true
+		}
+		edges := 0
+		for _, c := range f.Diagram.Model.Cells {
+			for _, ref := range []string{c.Parent, c.Source, c.Target} {
+				if ref != "" && !ids[ref] {
+					t.Errorf("cell %q refers to unknown cell %q", c.ID, ref)
+				}
+			}
+			if c.Edge == "1" {
+				edges++
+			}
+		}
+		if edges == 0 {
+			t.Errorf("no edges in:\n%s", buf.String())
+		}
+	}
+}
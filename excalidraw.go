@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/akavel/ditaa/fontmeasure"
+	"github.com/akavel/ditaa/graphical"
+)
+
+// excalidrawTypes maps the types of shapes to Excalidraw element types.
+// Excalidraw has no other closed shapes, so the rest become rectangles.
+var excalidrawTypes = map[graphical.ShapeType]string{
+	graphical.TYPE_DECISION: "diamond",
+	graphical.TYPE_ELLIPSE:  "ellipse",
+}
+
+// excalidrawArrowheads maps the styles of arrowheads to Excalidraw ones.
+var excalidrawArrowheads = map[graphical.ArrowheadStyle]string{
+	graphical.ARROWHEAD_FILLED:         "triangle",
+	graphical.ARROWHEAD_OPEN:           "arrow",
+	graphical.ARROWHEAD_DIAMOND:        "diamond",
+	graphical.ARROWHEAD_HOLLOW_DIAMOND: "diamond_outline",
+	graphical.ARROWHEAD_CIRCLE:         "circle_outline",
+	graphical.ARROWHEAD_CROWS_FOOT:     "crowfoot_many",
+}
+
+type excalidrawScene struct {
+	Type     string        `json:"type"`
+	Version  int           `json:"version"`
+	Source   string        `json:"source"`
+	Elements []interface{} `json:"elements"`
+	AppState struct {
+		ViewBackgroundColor string `json:"viewBackgroundColor"`
+		GridSize            *int   `json:"gridSize"`
+	} `json:"appState"`
+	Files struct{} `json:"files"`
+}
+
+type excalidrawElement struct {
+	ID              string                `json:"id"`
+	Type            string                `json:"type"`
+	X               float64               `json:"x"`
+	Y               float64               `json:"y"`
+	Width           float64               `json:"width"`
+	Height          float64               `json:"height"`
+	Angle           float64               `json:"angle"`
+	StrokeColor     string                `json:"strokeColor"`
+	BackgroundColor string                `json:"backgroundColor"`
+	FillStyle       string                `json:"fillStyle"`
+	StrokeWidth     float64               `json:"strokeWidth"`
+	StrokeStyle     string                `json:"strokeStyle"`
+	Roughness       int                   `json:"roughness"`
+	Opacity         int                   `json:"opacity"`
+	GroupIDs        []string              `json:"groupIds"`
+	Roundness       *excalidrawRoundness  `json:"roundness"`
+	Seed            int                   `json:"seed"`
+	Version         int                   `json:"version"`
+	VersionNonce    int                   `json:"versionNonce"`
+	IsDeleted       bool                  `json:"isDeleted"`
+	BoundElements   []excalidrawReference `json:"boundElements"`
+	Locked          bool                  `json:"locked"`
+}
+
+type excalidrawRoundness struct {
+	Type int `json:"type"`
+}
+
+type excalidrawReference struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+type excalidrawText struct {
+	excalidrawElement
+	Text          string  `json:"text"`
+	OriginalText  string  `json:"originalText"`
+	FontSize      float64 `json:"fontSize"`
+	FontFamily    int     `json:"fontFamily"`
+	TextAlign     string  `json:"textAlign"`
+	VerticalAlign string  `json:"verticalAlign"`
+	ContainerID   *string `json:"containerId"`
+	LineHeight    float64 `json:"lineHeight"`
+}
+
+type excalidrawArrow struct {
+	excalidrawElement
+	Points         [][2]float64       `json:"points"`
+	StartBinding   *excalidrawBinding `json:"startBinding"`
+	EndBinding     *excalidrawBinding `json:"endBinding"`
+	StartArrowhead *string            `json:"startArrowhead"`
+	EndArrowhead   *string            `json:"endArrowhead"`
+}
+
+type excalidrawBinding struct {
+	ElementID string  `json:"elementId"`
+	Focus     float64 `json:"focus"`
+	Gap       float64 `json:"gap"`
+}
+
+// excalidrawFontVirgil is the hand-drawn font of Excalidraw.
+const excalidrawFontVirgil = 1
+
+/*
+WriteExcalidraw writes the diagram as an Excalidraw scene:
+
+  - closed shapes become rectangles, diamonds or ellipses, filled with
+    the color from their color code,
+  - labels inside a shape become a single text element bound to it, the
+    other labels become free text elements,
+  - open shapes become arrows; their free ends are bound to the shapes
+    next to them (see ExtractGraph), and arrowheads are kept,
+  - point markers become small circles.
+*/
+func WriteExcalidraw(d *graphical.Diagram, w io.Writer) error {
+	g := ExtractGraph(d)
+	scene := excalidrawScene{Type: "excalidraw", Version: 2, Source: "ditaa"}
+	scene.AppState.ViewBackgroundColor = graphical.WHITE.Hex()
+	seed := 0
+	element := func(id, typ string, bb graphical.Rect) excalidrawElement {
+		seed++
+		return excalidrawElement{
+			ID:              id,
+			Type:            typ,
+			X:               bb.Min.X,
+			Y:               bb.Min.Y,
+			Width:           bb.Max.X - bb.Min.X,
+			Height:          bb.Max.Y - bb.Min.Y,
+			StrokeColor:     "#000000",
+			BackgroundColor: "transparent",
+			FillStyle:       "solid",
+			StrokeWidth:     1,
+			StrokeStyle:     "solid",
+			Roughness:       1,
+			Opacity:         100,
+			GroupIDs:        []string{},
+			Seed:            seed,
+			Version:         1,
+			VersionNonce:    seed,
+			BoundElements:   []excalidrawReference{},
+		}
+	}
+
+	shapes := map[*Node]*excalidrawElement{}
+	named := map[graphical.Label]bool{}
+	for i := range g.Nodes {
+		n := &g.Nodes[i]
+		typ, ok := excalidrawTypes[n.Shape.Type]
+		if !ok {
+			typ = "rectangle"
+		}
+		e := element(n.ID, typ, graphical.Bounds(n.Shape.Points))
+		e.StrokeColor = n.Shape.StrokeColor.Hex()
+		if n.FillColor != nil {
+			e.BackgroundColor = n.FillColor.Hex()
+		}
+		if n.Dashed {
+			e.StrokeStyle = "dashed"
+		}
+		if hasRoundCorners(n.Shape) {
+			e.Roundness = &excalidrawRoundness{Type: 3}
+		}
+		shapes[n] = &e
+		for _, label := range n.Labels {
+			named[label] = true
+		}
+	}
+
+	font := &fontmeasure.Font{Font: baseFont, DPI: 72}
+	texts := []interface{}{}
+	for i := range g.Nodes {
+		n := &g.Nodes[i]
+		if len(n.Labels) == 0 {
+			continue
+		}
+		bb := graphical.Bounds(n.Shape.Points)
+		text := excalidrawText{
+			excalidrawElement: element(n.ID+"-text", "text", bb),
+			Text:              joinLabels(n.Labels, "\n"),
+			FontSize:          n.Labels[0].FontSize,
+			FontFamily:        excalidrawFontVirgil,
+			TextAlign:         "center",
+			VerticalAlign:     "middle",
+			ContainerID:       &n.ID,
+			LineHeight:        1.25,
+		}
+		text.OriginalText = text.Text
+		text.StrokeColor = n.Labels[0].Color.Hex()
+		texts = append(texts, text)
+		container := shapes[n]
+		container.BoundElements = append(container.BoundElements, excalidrawReference{ID: text.ID, Type: "text"})
+	}
+	for i, label := range d.Labels {
+		if named[label] {
+			continue
+		}
+		text := excalidrawText{
+			excalidrawElement: element(fmt.Sprintf("t%d", i+1), "text", label.BoundsFor(font)),
+			Text:              label.Text,
+			OriginalText:      label.Text,
+			FontSize:          label.FontSize,
+			FontFamily:        excalidrawFontVirgil,
+			TextAlign:         "left",
+			VerticalAlign:     "top",
+			LineHeight:        1.25,
+		}
+		text.StrokeColor = label.Color.Hex()
+		texts = append(texts, text)
+	}
+
+	markers := []interface{}{}
+	for i, shape := range d.Shapes {
+		if shape.Type != graphical.TYPE_POINT_MARKER || len(shape.Points) != 1 {
+			continue
+		}
+		r := 0.35 * d.Grid.MinimumOfCellDimensions()
+		p := shape.Points[0]
+		e := element(fmt.Sprintf("m%d", i+1), "ellipse", graphical.Rect{
+			Min: graphical.Point{X: p.X - r, Y: p.Y - r},
+			Max: graphical.Point{X: p.X + r, Y: p.Y + r},
+		})
+		e.StrokeColor = shape.StrokeColor.Hex()
+		e.BackgroundColor = graphical.WHITE.Hex()
+		markers = append(markers, e)
+	}
+
+	arrows := []interface{}{}
+	lines, ends := connectLines(d, g)
+	for i, line := range lines {
+		points := append([]graphical.Point{ends[i][0].Point}, line.Points[1:len(line.Points)-1]...)
+		points = append(points, ends[i][1].Point)
+		arrow := excalidrawArrow{
+			excalidrawElement: element(fmt.Sprintf("e%d", i+1), "arrow", graphical.Bounds(points)),
+		}
+		arrow.StrokeColor = line.StrokeColor.Hex()
+		if line.Dashed {
+			arrow.StrokeStyle = "dashed"
+		}
+		arrow.X, arrow.Y = points[0].X, points[0].Y
+		for _, p := range points {
+			arrow.Points = append(arrow.Points, [2]float64{p.X - arrow.X, p.Y - arrow.Y})
+		}
+		for j, end := range ends[i] {
+			binding, head := &arrow.EndBinding, &arrow.EndArrowhead
+			if j == 0 {
+				binding, head = &arrow.StartBinding, &arrow.StartArrowhead
+			}
+			if end.Arrowhead {
+				name := excalidrawArrowheads[end.Style]
+				*head = &name
+			}
+			if end.Node != nil {
+				*binding = &excalidrawBinding{ElementID: end.Node.ID, Gap: 1}
+				shape := shapes[end.Node]
+				shape.BoundElements = append(shape.BoundElements, excalidrawReference{ID: arrow.ID, Type: "arrow"})
+			}
+		}
+		arrows = append(arrows, arrow)
+	}
+
+	scene.Elements = []interface{}{}
+	for i := range g.Nodes {
+		scene.Elements = append(scene.Elements, shapes[&g.Nodes[i]])
+	}
+	scene.Elements = append(scene.Elements, arrows...)
+	scene.Elements = append(scene.Elements, markers...)
+	scene.Elements = append(scene.Elements, texts...)
+
+	buf, err := json.MarshalIndent(&scene, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	_, err = w.Write(buf)
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteExcalidraw(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteExcalidraw(exportTestShapes(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type binding struct {
+		ElementID string `json:"elementId"`
+	}
+	scene := struct {
+		Type     string `json:"type"`
+		Elements []struct {
+			ID              string  `json:"id"`
+			Type            string  `json:"type"`
+			X               float64 `json:"x"`
+			Y               float64 `json:"y"`
+			Width           float64 `json:"width"`
+			Height          float64 `json:"height"`
+			BackgroundColor string  `json:"backgroundColor"`
+			StrokeStyle     string  `json:"strokeStyle"`
+			BoundElements   []struct {
+				ID string `json:"id"`
+			} `json:"boundElements"`
+			Points         [][2]float64 `json:"points"`
+			StartBinding   *binding     `json:"startBinding"`
+			EndBinding     *binding     `json:"endBinding"`
+			StartArrowhead *string      `json:"startArrowhead"`
+			EndArrowhead   *string      `json:"endArrowhead"`
+			Text           string       `json:"text"`
+			ContainerID    *string      `json:"containerId"`
+		} `json:"elements"`
+	}{}
+	err = json.Unmarshal(buf.Bytes(), &scene)
+	if err != nil {
+		t.Fatalf("%v in:\n%s", err, buf.String())
+	}
+	if scene.Type != "excalidraw" {
+		t.Errorf("got scene type %q, want excalidraw", scene.Type)
+	}
+
+	str := func(s *string) string {
+		if s == nil {
+			return "-"
+		}
+		return *s
+	}
+	id := func(b *binding) string {
+		if b == nil {
+			return "-"
+		}
+		return b.ElementID
+	}
+	got := []string{}
+	ids := map[string]bool{}
+	for _, e := range scene.Elements {
+		ids[e.ID] = true
+	}
+	for _, e := range scene.Elements {
+		s := fmt.Sprintf("%s %s %g,%g", e.ID, e.Type, e.X, e.Y)
+		switch e.Type {
+		case "arrow":
+			s += fmt.Sprintf(" %v %s %s->%s %s %s", e.Points, e.StrokeStyle,
+				id(e.StartBinding), id(e.EndBinding), str(e.StartArrowhead), str(e.EndArrowhead))
+			for _, b := range []*binding{e.StartBinding, e.EndBinding} {
+				if b != nil && !ids[b.ElementID] {
+					t.Errorf("%s is bound to unknown element %q", e.ID, b.ElementID)
+				}
+			}
+		case "text":
+			s += fmt.Sprintf(" %q in %s", e.Text, str(e.ContainerID))
+		default:
+			bound := []string{}
+			for _, b := range e.BoundElements {
+				bound = append(bound, b.ID)
+				if !ids[b.ID] {
+					t.Errorf("%s has unknown bound element %q", e.ID, b.ID)
+				}
+			}
+			s += fmt.Sprintf(" %gx%g %s [%s]", e.Width, e.Height, e.BackgroundColor, strings.Join(bound, " "))
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"n1 rectangle 5,7 40x28 transparent [n1-text e1 e2]",
+		"n2 rectangle 95,7 40x28 #5555bb [n2-text e1]",
+		"e1 arrow 45,21 [[0 0] [50 0]] solid n1->n2 - triangle",
+		"e2 arrow 25,35 [[0 0] [0 28] [40 28]] dashed n1->- - -",
+		"m6 ellipse 61.5,59.5 7x7 #ffffff []",
+		`n1-text text 5,7 "A" in n1`,
+		`n2-text text 95,7 "<B&>" in n2`,
+		`t3 text 75,57 "note" in -`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/akavel/ditaa/graphical"
+)
+
+// Graph is the semantic content of a diagram: which boxes are connected
+// to which by lines.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Node is a closed shape of the diagram. Its Name is made of the labels
+// drawn inside it (but not inside any smaller shape nested in it), in
+// reading order.
+type Node struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Type      string           `json:"type"`
+	FillColor *graphical.Color `json:"fillColor,omitempty"`
+	Dashed    bool             `json:"dashed,omitempty"`
+	// Shape is the shape in the diagram the node was extracted from.
+	Shape *graphical.Shape `json:"-"`
+	// Labels are the labels the Name was made of, in reading order.
+	Labels []graphical.Label `json:"-"`
+}
+
+// Edge is a set of connected lines joining two nodes. An edge is directed
+// if there is an arrowhead at the To end only; a line with arrowheads at
+// both ends produces two directed edges.
+type Edge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Directed bool   `json:"directed"`
+	Dashed   bool   `json:"dashed,omitempty"`
+	// Lines are the open shapes in the diagram the edge was extracted from.
+	Lines []*graphical.Shape `json:"-"`
+}
+
+// Node returns the node with the given ID, or nil.
+func (g *Graph) Node(id string) *Node {
+	for i := range g.Nodes {
+		if g.Nodes[i].ID == id {
+			return &g.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// WriteJSON serializes the graph as JSON.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	buf, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	_, err = w.Write(buf)
+	return err
+}
+
+/*
+ExtractGraph analyzes a diagram created by NewDiagram (or loaded from its
+XML/JSON form) and finds out what is connected to what:
+
+ 1. Every closed shape, except arrowheads and point markers, becomes
+    a node. Nodes are numbered in reading order of their top-left corners.
+ 2. Labels are assigned to the smallest node containing them.
+ 3. Open shapes sharing points are joined into networks of lines.
+ 4. Each free end of a network is attached to the smallest node whose
+    outline is at most one cell away from it. If the end is covered by
+    an arrowhead, the tip of the arrowhead is used instead, and the end
+    is marked as a head, unless the arrowhead is a marker not showing
+    a direction, like a diamond (see graphical.ArrowheadStyle.IsDirected).
+ 5. Every pair of attached ends produces an edge: from each plain end
+    to each head, or an undirected one if the network has no heads.
+
+Lines ending on the node they start from, and ends not attached to any
+node, are ignored.
+*/
+func ExtractGraph(d *graphical.Diagram) *Graph {
+	g := &Graph{Nodes: []Node{}, Edges: []Edge{}}
+
+	nodes, lines, arrowheads := splitShapes(d)
+	g.Nodes = append(g.Nodes, nodes...)
+	sort.Sort(nodesInReadingOrder(g.Nodes))
+	for i := range g.Nodes {
+		g.Nodes[i].ID = fmt.Sprintf("n%d", i+1)
+	}
+
+	nameNodes(g.Nodes, d.Labels)
+
+	tolerance := nodeTolerance(d.Grid)
+	type edgeKey struct {
+		from, to string
+		directed bool
+	}
+	seen := map[edgeKey]bool{}
+	for _, network := range joinLines(lines) {
+		heads, tails := []*Node{}, []*Node{}
+		dashed := false
+		for _, line := range network {
+			dashed = dashed || line.Dashed
+		}
+		for _, p := range networkEnds(network) {
+			end := lineEndAt(p, arrowheads)
+			node := findNodeNear(end.Point, g.Nodes, tolerance)
+			if node == nil {
+				continue
+			}
+			if end.Arrowhead && end.Style.IsDirected() {
+				heads = append(heads, node)
+			} else {
+				tails = append(tails, node)
+			}
+		}
+
+		add := func(from, to *Node, directed bool) {
+			if from == to {
+				return
+			}
+			if !directed && to.ID < from.ID {
+				from, to = to, from
+			}
+			key := edgeKey{from.ID, to.ID, directed}
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+			g.Edges = append(g.Edges, Edge{From: from.ID, To: to.ID, Directed: directed, Dashed: dashed, Lines: network})
+		}
+		switch {
+		case len(heads) == 0:
+			for i := range tails {
+				for j := i + 1; j < len(tails); j++ {
+					add(tails[i], tails[j], false)
+				}
+			}
+		case len(tails) == 0:
+			for i := range heads {
+				for j := range heads {
+					add(heads[i], heads[j], true)
+				}
+			}
+		default:
+			for _, from := range tails {
+				for _, to := range heads {
+					add(from, to, true)
+				}
+			}
+		}
+	}
+	sort.Sort(edgesByNodes(g.Edges))
+	return g
+}
+
+// lineEnd is an end of a single line of the diagram, as seen by the
+// exporters which keep the lines as they were drawn.
+type lineEnd struct {
+	// Point is the end of the line, or the tip of its arrowhead.
+	Point     graphical.Point
+	Arrowhead bool
+	Style     graphical.ArrowheadStyle // of the arrowhead, if any
+	// Node is the node the line is attached to at this end, or nil.
+	// Only the ends not touching other lines are attached.
+	Node *Node
+}
+
+// connectLines finds the open shapes of the diagram, and resolves their
+// ends against the nodes of g the same way ExtractGraph does.
+func connectLines(d *graphical.Diagram, g *Graph) (lines []*graphical.Shape, ends [][2]lineEnd) {
+	_, lines, arrowheads := splitShapes(d)
+	touching := map[[2]float64]int{}
+	for _, line := range lines {
+		for _, p := range line.Points {
+			touching[[2]float64{p.X, p.Y}]++
+		}
+	}
+	tolerance := nodeTolerance(d.Grid)
+	for _, line := range lines {
+		pair := [2]lineEnd{}
+		for i, p := range []graphical.Point{line.Points[0], line.Points[len(line.Points)-1]} {
+			end := lineEndAt(p, arrowheads)
+			if touching[[2]float64{p.X, p.Y}] == 1 {
+				end.Node = findNodeNear(end.Point, g.Nodes, tolerance)
+			}
+			pair[i] = end
+		}
+		ends = append(ends, pair)
+	}
+	return lines, ends
+}
+
+// splitShapes sorts the shapes of the diagram into the nodes (the closed
+// shapes, except arrowheads and point markers), without IDs and names
+// yet, the lines (the open shapes) and the arrowheads.
+func splitShapes(d *graphical.Diagram) (nodes []Node, lines, arrowheads []*graphical.Shape) {
+	for i := range d.Shapes {
+		shape := &d.Shapes[i]
+		switch {
+		case shape.Type == graphical.TYPE_ARROWHEAD:
+			arrowheads = append(arrowheads, shape)
+		case shape.Type == graphical.TYPE_POINT_MARKER:
+		case shape.Closed && len(shape.Points) > 2:
+			nodes = append(nodes, Node{
+				Type:      shape.Type.String(),
+				FillColor: shape.FillColor,
+				Dashed:    shape.Dashed,
+				Shape:     shape,
+			})
+		case !shape.Closed && len(shape.Points) > 1:
+			lines = append(lines, shape)
+		}
+	}
+	return nodes, lines, arrowheads
+}
+
+// nodeTolerance is the greatest distance between the end of a line and
+// the outline of the node it is attached to: one cell.
+func nodeTolerance(g graphical.Grid) float64 {
+	return math.Max(float64(g.CellW), float64(g.CellH))
+}
+
+// lineEndAt returns the end of a line at p, moved to the tip of the
+// arrowhead covering p, if there is one.
+func lineEndAt(p graphical.Point, arrowheads []*graphical.Shape) lineEnd {
+	end := lineEnd{Point: p}
+	if head := findArrowheadAt(p, arrowheads); head != nil {
+		end.Point = arrowheadTip(head, p)
+		end.Arrowhead = true
+		end.Style = head.Arrowhead
+	}
+	return end
+}
+
+type edgesByNodes []Edge
+
+func (t edgesByNodes) Len() int      { return len(t) }
+func (t edgesByNodes) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t edgesByNodes) Less(i, j int) bool {
+	if t[i].From != t[j].From {
+		return nodeNumber(t[i].From) < nodeNumber(t[j].From)
+	}
+	return nodeNumber(t[i].To) < nodeNumber(t[j].To)
+}
+
+func nodeNumber(id string) int {
+	n := 0
+	fmt.Sscanf(id, "n%d", &n)
+	return n
+}
+
+type nodesInReadingOrder []Node
+
+func (t nodesInReadingOrder) Len() int      { return len(t) }
+func (t nodesInReadingOrder) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t nodesInReadingOrder) Less(i, j int) bool {
+	bi, bj := graphical.Bounds(t[i].Shape.Points), graphical.Bounds(t[j].Shape.Points)
+	if bi.Min.Y != bj.Min.Y {
+		return bi.Min.Y < bj.Min.Y
+	}
+	if bi.Min.X != bj.Min.X {
+		return bi.Min.X < bj.Min.X
+	}
+	return bi.Area() > bj.Area()
+}
+
+func nameNodes(nodes []Node, labels []graphical.Label) {
+	shapes := make([]graphical.Shape, len(nodes))
+	for i := range nodes {
+		shapes[i] = *nodes[i].Shape
+	}
+	names := make([][]graphical.Label, len(nodes))
+	for _, label := range labels {
+		shape := FindSmallestShapeContaining(graphical.Point{X: float64(label.X), Y: float64(label.Y - 1)}, shapes)
+		if shape == nil {
+			continue
+		}
+		i := indexOfShape(shape, shapes)
+		names[i] = append(names[i], label)
+	}
+	for i, texts := range names {
+		sort.Sort(labelsInReadingOrder(texts))
+		nodes[i].Labels = texts
+		nodes[i].Name = joinLabels(texts, " ")
+	}
+}
+
+func joinLabels(labels []graphical.Label, sep string) string {
+	texts := []string{}
+	for _, label := range labels {
+		texts = append(texts, strings.TrimSpace(label.Text))
+	}
+	return strings.Join(texts, sep)
+}
+
+func indexOfShape(shape *graphical.Shape, shapes []graphical.Shape) int {
+	for i := range shapes {
+		if &shapes[i] == shape {
+			return i
+		}
+	}
+	return -1
+}
+
+type labelsInReadingOrder []graphical.Label
+
+func (t labelsInReadingOrder) Len() int      { return len(t) }
+func (t labelsInReadingOrder) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t labelsInReadingOrder) Less(i, j int) bool {
+	if t[i].Y != t[j].Y {
+		return t[i].Y < t[j].Y
+	}
+	return t[i].X < t[j].X
+}
+
+// joinLines groups the lines into networks of lines touching each other.
+func joinLines(lines []*graphical.Shape) [][]*graphical.Shape {
+	parent := make([]int, len(lines))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	owner := map[[2]float64]int{}
+	for i, line := range lines {
+		for _, p := range line.Points {
+			key := [2]float64{p.X, p.Y}
+			if j, ok := owner[key]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				owner[key] = i
+			}
+		}
+	}
+	networks := [][]*graphical.Shape{}
+	index := map[int]int{}
+	for i, line := range lines {
+		root := find(i)
+		n, ok := index[root]
+		if !ok {
+			n = len(networks)
+			index[root] = n
+			networks = append(networks, nil)
+		}
+		networks[n] = append(networks[n], line)
+	}
+	return networks
+}
+
+// networkEnds returns the free ends of a network of lines, i.e. the
+// first and last points of lines which don't touch any other line.
+func networkEnds(network []*graphical.Shape) []graphical.Point {
+	count := map[[2]float64]int{}
+	for _, line := range network {
+		for _, p := range line.Points {
+			count[[2]float64{p.X, p.Y}]++
+		}
+	}
+	ends := []graphical.Point{}
+	for _, line := range network {
+		for _, p := range []graphical.Point{line.Points[0], line.Points[len(line.Points)-1]} {
+			if count[[2]float64{p.X, p.Y}] == 1 {
+				ends = append(ends, p)
+			}
+		}
+	}
+	return ends
+}
+
+func findArrowheadAt(p graphical.Point, arrowheads []*graphical.Shape) *graphical.Shape {
+	for _, head := range arrowheads {
+		if graphical.Bounds(head.Points).Contains(p) {
+			return head
+		}
+	}
+	return nil
+}
+
+// arrowheadTip returns the tip of the arrowhead, or if it isn't a proper
+// triangle, its vertex closest to the center of its cell, p.
+func arrowheadTip(head *graphical.Shape, p graphical.Point) graphical.Point {
+	if tip, _, _, ok := graphical.ArrowheadTip(head.Points); ok {
+		return tip
+	}
+	tip := head.Points[0]
+	for _, q := range head.Points[1:] {
+		if distance(p, q) < distance(p, tip) {
+			tip = q
+		}
+	}
+	return tip
+}
+
+// findNodeNear returns the smallest node with outline not further than
+// tolerance from p.
+func findNodeNear(p graphical.Point, nodes []Node, tolerance float64) *Node {
+	var found *Node
+	for i := range nodes {
+		node := &nodes[i]
+		if distanceToOutline(p, node.Shape.Points) > tolerance {
+			continue
+		}
+		if found == nil || node.Shape.SmallerThan(found.Shape) {
+			found = node
+		}
+	}
+	return found
+}
+
+func distanceToOutline(p graphical.Point, outline []graphical.Point) float64 {
+	min := math.Inf(1)
+	for i := range outline {
+		d := distanceToSegment(p, outline[i], outline[(i+1)%len(outline)])
+		if d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+func distanceToSegment(p, a, b graphical.Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	lensq := dx*dx + dy*dy
+	if lensq == 0 {
+		return distance(p, a)
+	}
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / lensq
+	t = math.Max(0, math.Min(1, t))
+	return distance(p, graphical.Point{X: a.X + t*dx, Y: a.Y + t*dy})
+}
+
+func distance(p, q graphical.Point) float64 {
+	return math.Hypot(p.X-q.X, p.Y-q.Y)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/akavel/ditaa/graphical"
+)
+
+func TestExtractGraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		nodes []string // names, by ID
+		edges []Edge
+	}{
+		{"box to box", `
++---+    +---+
+| A |--->| B |
++---+    +---+`,
+			[]string{"A", "B"},
+			[]Edge{{From: "n1", To: "n2", Directed: true}}},
+		{"bidirectional", `
++---+    +---+
+| A |<-->| B |
++---+    +---+`,
+			[]string{"A", "B"},
+			[]Edge{{From: "n1", To: "n2", Directed: true}, {From: "n2", To: "n1", Directed: true}}},
+		{"undirected, dashed", `
++---+    +---+
+| A |-=--| B |
++---+    +---+`,
+			[]string{"A", "B"},
+			[]Edge{{From: "n1", To: "n2", Dashed: true}}},
+		{"branch", `
++---+        +---+
+| A |---+--->| B |
++---+   |    +---+
+        |
+        |    +---+
+        +--->| C |
+             +---+`,
+			[]string{"A", "B", "C"},
+			[]Edge{{From: "n1", To: "n2", Directed: true}, {From: "n1", To: "n3", Directed: true}}},
+		{"dangling", `
++---+
+| A |---->
++---+
+
+--------`,
+			[]string{"A"},
+			[]Edge{}},
+	}
+	for _, test := range tests {
+		grid := NewTextGrid(0, 0)
+		grid.LoadFrom(strings.NewReader(test.text))
+		d, err := NewDiagram(grid)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		g := ExtractGraph(&d.G)
+		names := []string{}
+		for i, n := range g.Nodes {
+			if want := fmt.Sprintf("n%d", i+1); n.ID != want {
+				t.Errorf("%s: node %d has ID %s, want %s", test.name, i, n.ID, want)
+			}
+			names = append(names, n.Name)
+		}
+		if !reflect.DeepEqual(names, test.nodes) {
+			t.Errorf("%s: got nodes %q, want %q", test.name, names, test.nodes)
+		}
+		for i := range g.Edges {
+			if len(g.Edges[i].Lines) == 0 {
+				t.Errorf("%s: edge %d has no lines", test.name, i)
+			}
+			g.Edges[i].Lines = nil
+		}
+		if !reflect.DeepEqual(g.Edges, test.edges) {
+			t.Errorf("%s: got edges %+v, want %+v", test.name, g.Edges, test.edges)
+		}
+	}
+}
+
+func TestGraphWriteJSON(t *testing.T) {
+	g := &Graph{
+		Nodes: []Node{{ID: "n1", Name: "A", Type: "simple"}, {ID: "n2", Name: "B", Type: "storage", Dashed: true}},
+		Edges: []Edge{{From: "n1", To: "n2", Directed: true}},
+	}
+	var buf bytes.Buffer
+	if err := g.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v in %q", err, buf.String())
+	}
+	want := map[string]interface{}{
+		"nodes": []interface{}{
+			map[string]interface{}{"id": "n1", "name": "A", "type": "simple"},
+			map[string]interface{}{"id": "n2", "name": "B", "type": "storage", "dashed": true},
+		},
+		"edges": []interface{}{
+			map[string]interface{}{"from": "n1", "to": "n2", "directed": true},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestJoinLines(t *testing.T) {
+	line := func(xy ...float64) *graphical.Shape {
+		s := graphical.NewShape()
+		for i := 0; i < len(xy); i += 2 {
+			s.Points = append(s.Points, graphical.Point{X: xy[i], Y: xy[i+1]})
+		}
+		return s
+	}
+	lines := []*graphical.Shape{
+		line(0, 0, 10, 0),           // a
+		line(50, 50, 60, 50),        // b
+		line(20, 0, 30, 0),          // c, joined to a through e
+		line(10, 0, 10, 10, 20, 10), // d, joined to a
+		line(20, 10, 20, 0),         // e, joined to d and c
+		line(60, 50, 60, 60),        // f, joined to b
+		line(5, 0, 5, 5),            // g, crosses a but shares no point
+	}
+	got := [][]int{}
+	for _, network := range joinLines(lines) {
+		ids := []int{}
+		for _, l := range network {
+			ids = append(ids, indexOfLine(l, lines))
+		}
+		got = append(got, ids)
+	}
+	want := [][]int{{0, 2, 3, 4}, {1, 5}, {6}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func indexOfLine(l *graphical.Shape, lines []*graphical.Shape) int {
+	for i := range lines {
+		if lines[i] == l {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestFindNodeNear(t *testing.T) {
+	box := func(x0, y0, x1, y1 float64) *graphical.Shape {
+		return graphical.NewShape(
+			graphical.Point{X: x0, Y: y0}, graphical.Point{X: x1, Y: y0},
+			graphical.Point{X: x1, Y: y1}, graphical.Point{X: x0, Y: y1})
+	}
+	nodes := []Node{
+		{ID: "outer", Shape: box(0, 0, 100, 100)},
+		{ID: "inner", Shape: box(40, 40, 60, 60)},
+	}
+	tests := []struct {
+		p    graphical.Point
+		want string
+	}{
+		{graphical.Point{X: 50, Y: -10}, "outer"}, // at the tolerance
+		{graphical.Point{X: 50, Y: -10.5}, ""},
+		{graphical.Point{X: 107, Y: 107}, "outer"}, // near the corner
+		{graphical.Point{X: 108, Y: 108}, ""},
+		{graphical.Point{X: 50, Y: 5}, "outer"},
+		{graphical.Point{X: 50, Y: 35}, "inner"}, // near both, the smaller wins
+		{graphical.Point{X: 50, Y: 50}, "inner"},
+	}
+	for _, test := range tests {
+		got := ""
+		if node := findNodeNear(test.p, nodes, 10); node != nil {
+			got = node.ID
+		}
+		if got != test.want {
+			t.Errorf("%v: got %q, want %q", test.p, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package graphical
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ArrowheadStyle is the way an arrowhead (a shape of TYPE_ARROWHEAD) is
+// drawn. Whatever the style, the points of the shape are the triangle of
+// the filled arrowhead, pointing away from the line it ends; the other
+// styles are drawn inside that triangle.
+type ArrowheadStyle int
+
+const (
+	ARROWHEAD_FILLED ArrowheadStyle = iota
+	ARROWHEAD_OPEN                  // a "V" of two strokes
+	ARROWHEAD_DIAMOND
+	ARROWHEAD_HOLLOW_DIAMOND
+	ARROWHEAD_CIRCLE // hollow
+	ARROWHEAD_CROWS_FOOT
+)
+
+var arrowheadStyleNames = map[ArrowheadStyle]string{
+	ARROWHEAD_FILLED:         "filled",
+	ARROWHEAD_OPEN:           "open",
+	ARROWHEAD_DIAMOND:        "diamond",
+	ARROWHEAD_HOLLOW_DIAMOND: "hollow-diamond",
+	ARROWHEAD_CIRCLE:         "circle",
+	ARROWHEAD_CROWS_FOOT:     "crows-foot",
+}
+
+func (a ArrowheadStyle) String() string {
+	if name, ok := arrowheadStyleNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("ArrowheadStyle(%d)", int(a))
+}
+
+// ArrowheadStyleNames returns the names of all the styles, as accepted by
+// ParseArrowheadStyle, sorted.
+func ArrowheadStyleNames() []string {
+	names := []string{}
+	for _, name := range arrowheadStyleNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseArrowheadStyle returns the style with the name returned by String.
+func ParseArrowheadStyle(name string) (ArrowheadStyle, bool) {
+	for a, n := range arrowheadStyleNames {
+		if n == name {
+			return a, true
+		}
+	}
+	return ARROWHEAD_FILLED, false
+}
+
+// IsDirected tells if the style shows the direction of the line, like
+// the filled and open arrowheads do. The other ones are markers of the
+// kind of the relationship, as in UML and ER diagrams.
+func (a ArrowheadStyle) IsDirected() bool {
+	return a == ARROWHEAD_FILLED || a == ARROWHEAD_OPEN
+}
+
+// paint tells how the arrowheads of the style are drawn: filled with
+// their fill color, filled white (hollow), and/or stroked.
+func (a ArrowheadStyle) paint() (filled, hollow, stroked bool) {
+	switch a {
+	case ARROWHEAD_FILLED, ARROWHEAD_DIAMOND:
+		return true, false, false
+	case ARROWHEAD_HOLLOW_DIAMOND, ARROWHEAD_CIRCLE:
+		return false, true, true
+	}
+	return false, false, true
+}
+
+// ArrowheadTip splits the triangle of an arrowhead into its tip, i.e. the
+// vertex as far from both of the other ones, and the two ends of its base.
+func ArrowheadTip(points []Point) (tip, base1, base2 Point, ok bool) {
+	if len(points) != 3 {
+		return Point{}, Point{}, Point{}, false
+	}
+	dist := func(a, b Point) float64 { return math.Hypot(b.X-a.X, b.Y-a.Y) }
+	for i, tip := range points {
+		a, b := points[(i+1)%3], points[(i+2)%3]
+		if math.Abs(dist(tip, a)-dist(tip, b)) <= 0.5 {
+			return tip, a, b, true
+		}
+	}
+	return Point{}, Point{}, Point{}, false
+}
+
+// makeArrowheadPath returns the outline of an arrowhead drawn in a style
+// other than ARROWHEAD_FILLED. The outlines of the open styles, i.e. the
+// "V" and the crow's foot, include the stem from the middle of the base
+// to the tip, so that they join the line wherever it ends.
+func (s *Shape) makeArrowheadPath(g Grid, opt Options) Path {
+	tip, b1, b2, ok := ArrowheadTip(s.Points)
+	if !ok {
+		return s.makeOtherPath(g, opt)
+	}
+	mid := Point{X: (b1.X + b2.X) / 2, Y: (b1.Y + b2.Y) / 2}
+	// along the arrowhead, and across it from the middle of the base
+	ux, uy := tip.X-mid.X, tip.Y-mid.Y
+	px, py := b1.X-mid.X, b1.Y-mid.Y
+	at := func(u, p float64) Point {
+		return Point{X: mid.X + u*ux + p*px, Y: mid.Y + u*uy + p*py}
+	}
+
+	path := Path{}
+	switch s.Arrowhead {
+	case ARROWHEAD_OPEN:
+		path.Start(b1)
+		path.Add1(tip)
+		path.Add1(b2)
+		path.Add1(tip)
+		path.Add1(mid)
+	case ARROWHEAD_DIAMOND, ARROWHEAD_HOLLOW_DIAMOND:
+		path.Start(tip)
+		path.Add1(at(0.5, 0.6))
+		path.Add1(mid)
+		path.Add1(at(0.5, -0.6))
+		path.Add1(tip)
+	case ARROWHEAD_CIRCLE:
+		r := math.Min(math.Hypot(ux, uy)/2, math.Hypot(px, py))
+		return Ellipse(at(0.5, 0), r, r)
+	case ARROWHEAD_CROWS_FOOT:
+		path.Start(at(1, 1))
+		path.Add1(mid)
+		path.Add1(at(1, -1))
+		path.Add1(mid)
+		path.Add1(tip)
+	default:
+		return s.makeOtherPath(g, opt)
+	}
+	return path
+}
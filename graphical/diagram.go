@@ -1,9 +1,9 @@
 package graphical
 
 import (
+	"context"
 	"encoding/xml"
 	"image"
-	"image/color"
 	"sort"
 
 	"github.com/akavel/ditaa/fontmeasure"
@@ -17,14 +17,14 @@ import (
 const DEBUG = true
 
 type Label struct {
-	Text         string  `xml:"text"`
-	FontSize     float64 `xml:"font>size"`
-	X            int     `xml:"xPos"`
-	Y            int     `xml:"yPos"`
-	Color        Color   `xml:"color"`
-	OnLine       bool    `xml:"isTextOnLine"`
-	Outline      bool    `xml:"hasOutline"`
-	OutlineColor Color   `xml:"outlineColor"`
+	Text         string  `xml:"text" json:"text"`
+	FontSize     float64 `xml:"font>size" json:"fontSize"`
+	X            int     `xml:"xPos" json:"x"`
+	Y            int     `xml:"yPos" json:"y"`
+	Color        Color   `xml:"color" json:"color"`
+	OnLine       bool    `xml:"isTextOnLine" json:"onLine"`
+	Outline      bool    `xml:"hasOutline" json:"outline"`
+	OutlineColor Color   `xml:"outlineColor" json:"outlineColor"`
 }
 
 func (l *Label) CenterVerticallyBetween(minY, maxY int, font *fontmeasure.Font) {
@@ -63,14 +63,52 @@ func (l *Label) BoundsFor(font *fontmeasure.Font) Rect {
 }
 
 type Diagram struct {
-	XMLName xml.Name `xml:"diagram"`
-	Grid    Grid     `xml:"grid"`
-	Shapes  []Shape  `xml:"shapes>shape"`
-	Labels  []Label  `xml:"texts>text"`
+	XMLName xml.Name `xml:"diagram" json:"-"`
+	Grid    Grid     `xml:"grid" json:"grid"`
+	Shapes  []Shape  `xml:"shapes>shape" json:"shapes"`
+	Labels  []Label  `xml:"texts>text" json:"labels"`
 }
 
 type Options struct {
 	DropShadows bool
+	// FixedSlope makes the sides of IO and trapezoid shapes slope at the
+	// same angle whatever their height, instead of by half a cell.
+	FixedSlope bool
+	// CornerRadius is the radius of round corners, in pixels. If it is 0,
+	// round corners are cut from the edges of their cells.
+	CornerRadius float64
+	// LineHops draws a small hop in the horizontal lines where they cross
+	// vertical ones without a joint, as in -|-.
+	LineHops bool
+	// Theme sets the background, shadows and sizes; nil means
+	// DefaultTheme. The other colors are set by NewDiagram.
+	Theme *Theme
+}
+
+func (opt Options) theme() *Theme {
+	if opt.Theme == nil {
+		return DefaultTheme()
+	}
+	return opt.Theme
+}
+
+// lineHops returns the hops of the shapes (see findLineHops) if they are
+// enabled by opt, or nil.
+func lineHops(shapes []Shape, opt Options) [][]Point {
+	if !opt.LineHops {
+		return nil
+	}
+	return findLineHops(shapes)
+}
+
+// makeIntoOutlineWithHops is MakeIntoOutline with the hops found by
+// lineHops for the i-th shape.
+func (s *Shape) makeIntoOutlineWithHops(g Grid, opt Options, hops [][]Point, i int) Path {
+	path := s.MakeIntoOutline(g, opt)
+	if hops == nil {
+		return path
+	}
+	return path.withHops(hops[i], HOP_SIZE*g.MinimumOfCellDimensions())
 }
 
 func renderShadows(img *image.RGBA, shapes []Shape, g Grid, opt Options) {
@@ -78,11 +116,11 @@ func renderShadows(img *image.RGBA, shapes []Shape, g Grid, opt Options) {
 		if len(shape.Points) == 0 || !shape.DropsShadow() || shape.Type == TYPE_CUSTOM {
 			continue
 		}
-		path := shape.MakeIntoRenderPath(g /*, opt*/)
+		path := shape.MakeIntoRenderPath(g, opt)
 		if path == nil {
 			continue
 		}
-		Fill(img, path, color.RGBA{150, 150, 150, 255})
+		Fill(img, path, opt.theme().Shadow.RGBA())
 	}
 	offset := g.CellW
 	if g.CellH < offset {
@@ -94,8 +132,8 @@ func renderShadows(img *image.RGBA, shapes []Shape, g Grid, opt Options) {
 	*img = *img2
 }
 
-func blurShadows(img *image.RGBA) {
-	radius := 4
+func blurShadows(img *image.RGBA, t *Theme) {
+	radius := t.ShadowBlur
 	StackBlur(img, radius, true)
 
 	// remove blur artifacts from the top-left border of image
@@ -103,12 +141,12 @@ func blurShadows(img *image.RGBA) {
 	radius += 2
 	for y := bb.Min.Y; y <= bb.Min.Y+radius; y++ {
 		for x := bb.Min.X; x <= bb.Max.X; x++ {
-			img.SetRGBA(x, y, WHITE.RGBA())
+			img.SetRGBA(x, y, t.Background.RGBA())
 		}
 	}
 	for y := bb.Min.Y + radius + 1; y <= bb.Max.Y; y++ {
 		for x := bb.Min.X; x <= bb.Min.X+radius; x++ {
-			img.SetRGBA(x, y, WHITE.RGBA())
+			img.SetRGBA(x, y, t.Background.RGBA())
 		}
 	}
 }
@@ -123,10 +161,28 @@ func (t LargeFirst) Swap(i, j int) {
 	t[j] = tmp
 }
 
+// BackToFront puts shapes in pseudo-3d order, from the ones lowest on the
+// image (at the back) to the highest (at the front).
+type BackToFront []Shape
+
+func (t BackToFront) Len() int      { return len(t) }
+func (t BackToFront) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t BackToFront) Less(i, j int) bool {
+	bi, bj := Bounds(t[i].Points), Bounds(t[j].Points)
+	return bi.Min.Y+bi.Max.Y > bj.Min.Y+bj.Max.Y
+}
+
 func RenderDiagram(img *image.RGBA, diagram *Diagram, opt Options, font *truetype.Font) error {
+	return RenderDiagramContext(context.Background(), img, diagram, opt, font)
+}
+
+// RenderDiagramContext is like RenderDiagram, but stops and returns the
+// context's error as soon as it is done.
+func RenderDiagramContext(ctx context.Context, img *image.RGBA, diagram *Diagram, opt Options, font *truetype.Font) error {
+	theme := opt.theme()
 	for y := 0; y < diagram.Grid.H; y++ {
 		for x := 0; x < diagram.Grid.W; x++ {
-			img.SetRGBA(x, y, WHITE.RGBA())
+			img.SetRGBA(x, y, theme.Background.RGBA())
 		}
 	}
 
@@ -138,7 +194,10 @@ func RenderDiagram(img *image.RGBA, diagram *Diagram, opt Options, font *truetyp
 
 		//TODO: blur shadows
 		if true {
-			blurShadows(img)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			blurShadows(img, theme)
 		}
 	}
 
@@ -150,28 +209,42 @@ func RenderDiagram(img *image.RGBA, diagram *Diagram, opt Options, font *truetyp
 	storageShapes := []Shape{}
 	for _, shape := range diagram.Shapes {
 		if shape.Type == TYPE_STORAGE {
-			//TODO: freetype-go doesn't implement stroking cubic paths -- need to fix or walk around
-			//storageShapes = append(storageShapes, shape)
+			storageShapes = append(storageShapes, shape)
 		}
 	}
-	//TODO: sort storage shapes
+	sort.Sort(BackToFront(storageShapes))
 	for _, shape := range storageShapes {
-		path := shape.MakeIntoRenderPath(diagram.Grid /*, opt*/)
-		//TODO: handle dashed
-		color := WHITE
-		if shape.FillColor != nil {
-			color = *shape.FillColor
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		Fill(img, path, color.RGBA())
-		Stroke(img, path, shape.StrokeColor.RGBA())
+		path := shape.MakeIntoRenderPath(diagram.Grid, opt)
+		if path == nil {
+			continue
+		}
+		if !shape.Dashed {
+			color := theme.Background
+			if shape.FillColor != nil {
+				color = *shape.FillColor
+			}
+			Fill(img, path, color.RGBA())
+		}
+		//TODO: support dashed lines
+		Stroke(img, path, theme.StrokeWidth, shape.StrokeColor.RGBA())
 	}
 
 	sort.Sort(LargeFirst(diagram.Shapes))
+	hops := lineHops(diagram.Shapes, opt)
 
-	// render rest of shapes + collect point markers
-	pointMarkers := []Shape{}
-	for _, shape := range diagram.Shapes {
+	// render rest of shapes + collect arrowheads and point markers
+	arrowheads, pointMarkers := []Shape{}, []Shape{}
+	for i, shape := range diagram.Shapes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		switch shape.Type {
+		case TYPE_ARROWHEAD:
+			arrowheads = append(arrowheads, shape)
+			continue
 		case TYPE_POINT_MARKER:
 			pointMarkers = append(pointMarkers, shape)
 			continue
@@ -185,11 +258,11 @@ func RenderDiagram(img *image.RGBA, diagram *Diagram, opt Options, font *truetyp
 			continue
 		}
 
-		path := shape.MakeIntoRenderPath(diagram.Grid /*, opt*/)
+		path := shape.makeIntoOutlineWithHops(diagram.Grid, opt, hops, i).Raster()
 
 		// fill
 		if path != nil && shape.Closed && !shape.Dashed {
-			color := WHITE
+			color := theme.Background
 			if shape.FillColor != nil {
 				color = *shape.FillColor
 			}
@@ -197,29 +270,51 @@ func RenderDiagram(img *image.RGBA, diagram *Diagram, opt Options, font *truetyp
 		}
 
 		// draw
-		if shape.Type != TYPE_ARROWHEAD {
-			//TODO: support dashed lines
-			Stroke(img, path, shape.StrokeColor.RGBA())
+		//TODO: support dashed lines
+		Stroke(img, path, theme.StrokeWidth, shape.StrokeColor.RGBA())
+	}
+
+	// render arrowheads over the ends of the lines, which the hollow ones
+	// hide
+	for _, shape := range arrowheads {
+		path := shape.MakeIntoRenderPath(diagram.Grid, opt)
+		if path == nil {
+			continue
+		}
+		filled, hollow, stroked := shape.Arrowhead.paint()
+		switch {
+		case filled && shape.FillColor != nil:
+			Fill(img, path, shape.FillColor.RGBA())
+		case filled:
+			Fill(img, path, shape.StrokeColor.RGBA())
+		case hollow:
+			Fill(img, path, theme.Background.RGBA())
+		}
+		if stroked {
+			Stroke(img, path, theme.StrokeWidth, shape.StrokeColor.RGBA())
 		}
 	}
 
 	// render point markers
 	for _, shape := range pointMarkers {
-		outer, inner := shape.MakeMarkerPaths(diagram.Grid)
+		outer, inner := shape.MakeMarkerPaths(diagram.Grid, theme)
 		Fill(img, outer, shape.StrokeColor.RGBA())
-		Fill(img, inner, WHITE.RGBA())
+		Fill(img, inner, theme.Background.RGBA())
 	}
 
 	// handle text
 	for _, label := range diagram.Labels {
-		ctx := freetype.NewContext()
-		ctx.SetFont(font)
-		ctx.SetFontSize(label.FontSize)
-		ctx.SetSrc(image.NewUniform(label.Color.RGBA()))
-		ctx.SetDst(img)
-		ctx.SetClip(img.Bounds())
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fc := freetype.NewContext()
+		fc.SetFont(font)
+		fc.SetFontSize(label.FontSize)
+		fc.SetSrc(image.NewUniform(label.Color.RGBA()))
+		fc.SetDst(img)
+		fc.SetClip(img.Bounds())
 		//TODO: handle outline
-		ctx.DrawString(label.Text, P(Point{X: float64(label.X), Y: float64(label.Y)}))
+		fc.DrawString(label.Text, P(Point{X: float64(label.X), Y: float64(label.Y)}))
 	}
 	return nil
 }
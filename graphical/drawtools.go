@@ -1,6 +1,7 @@
 package graphical
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"math"
@@ -14,14 +15,21 @@ const (
 )
 
 type Color struct {
-	R uint8 `xml:"r,attr"`
-	G uint8 `xml:"g,attr"`
-	B uint8 `xml:"b,attr"`
-	A uint8 `xml:"a,attr"`
+	R uint8 `xml:"r,attr" json:"r"`
+	G uint8 `xml:"g,attr" json:"g"`
+	B uint8 `xml:"b,attr" json:"b"`
+	A uint8 `xml:"a,attr" json:"a"`
 }
 
+// RGBA returns the color premultiplied by its alpha, as the painters
+// expect it.
 func (c Color) RGBA() color.RGBA {
-	return color.RGBA{c.R, c.G, c.B, c.A}
+	return color.RGBAModel.Convert(color.NRGBA{c.R, c.G, c.B, c.A}).(color.RGBA)
+}
+
+// Hex returns the color in the "#rrggbb" notation, ignoring alpha.
+func (c Color) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
 }
 
 var WHITE = Color{255, 255, 255, 255}
@@ -34,10 +42,10 @@ const (
 )
 
 type Point struct {
-	X      float64   `xml:"x,attr"`
-	Y      float64   `xml:"y,attr"`
-	Locked bool      `xml:"locked,attr"`
-	Type   PointType `xml:"type,attr"`
+	X      float64   `xml:"x,attr" json:"x"`
+	Y      float64   `xml:"y,attr" json:"y"`
+	Locked bool      `xml:"locked,attr" json:"locked"`
+	Type   PointType `xml:"type,attr" json:"type"`
 }
 
 func (p1 Point) NorthOf(p2 Point) bool { return p1.Y < p2.Y }
@@ -46,24 +54,19 @@ func (p1 Point) WestOf(p2 Point) bool  { return p1.X < p2.X }
 func (p1 Point) EastOf(p2 Point) bool  { return p1.X > p2.X }
 
 func P(p Point) raster.Point {
-	//TODO: handle fractional part too, but probably not needed
-	return raster.Point{
-		raster.Fix32(int(p.X)) << 8,
-		raster.Fix32(int(p.Y)) << 8,
-	}
+	return raster.Point{X: ftofix(p.X), Y: ftofix(p.Y)}
 }
 
+// ftofix converts f to the 24.8 fixed point format of the rasterizer,
+// rounding it down.
 func ftofix(f float64) raster.Fix32 {
-	//TODO: verify this is OK
-	a := math.Trunc(f)
-	b := math.Ldexp(math.Abs(f-a), 8)
-	return raster.Fix32(a)<<8 + raster.Fix32(b)
+	return raster.Fix32(math.Floor(math.Ldexp(f, 8)))
 }
 
-func Stroke(img *image.RGBA, path raster.Path, color color.RGBA) {
+func Stroke(img *image.RGBA, path raster.Path, width float64, color color.RGBA) {
 	//TODO: support dashed lines
 	g := raster.NewRasterizer(img.Rect.Max.X+1, img.Rect.Max.Y+1) //TODO: +1 or not?
-	raster.Stroke(g, path, ftofix(STROKE_WIDTH), nil, nil)
+	raster.Stroke(g, path, ftofix(width), nil, nil)
 	painter := raster.NewRGBAPainter(img)
 	painter.SetColor(color)
 	g.Rasterize(painter)
@@ -78,23 +81,35 @@ func Fill(img *image.RGBA, path raster.Path, color color.RGBA) {
 }
 
 func Circle(x, y, r float64) raster.Path {
-	//panic(fmt.Sprint(x, y, r))
-	P := func(x, y float64) raster.Point {
-		return raster.Point{ftofix(x), ftofix(y)}
-	}
-	p1 := P(x+r, y)
-	p2 := P(x, y+r)
-	p3 := P(x-r, y)
-	p4 := P(x, y-r)
-	kr := MAGIC_K * r
 	path := raster.Path{}
+	for _, seg := range Ellipse(Point{X: x, Y: y}, r, r) {
+		pts := seg.Points
+		switch seg.Op {
+		case PATH_START:
+			path.Start(P(pts[0]))
+		case PATH_CUBIC:
+			path.Add3(P(pts[0]), P(pts[1]), P(pts[2]))
+		}
+	}
+	return path
+}
+
+// Ellipse returns the outline of the ellipse with the center c and the
+// radii rx and ry, made of four cubic curves.
+func Ellipse(c Point, rx, ry float64) Path {
+	right := Point{X: c.X + rx, Y: c.Y}
+	bottom := Point{X: c.X, Y: c.Y + ry}
+	left := Point{X: c.X - rx, Y: c.Y}
+	top := Point{X: c.X, Y: c.Y - ry}
+	kx, ky := MAGIC_K*rx, MAGIC_K*ry
+	path := Path{}
 	// see: http://hansmuller-flex.blogspot.com/2011/04/approximating-circular-arc-with-cubic.html
 	//  or: http://www.whizkidtech.redprince.net/bezier/circle/
 	// etc. -- google "drawing circle with cubic curves"
-	path.Start(p1)
-	path.Add3(P(x+r, y+kr), P(x+kr, y+r), p2)
-	path.Add3(P(x-kr, y+r), P(x-r, y+kr), p3)
-	path.Add3(P(x-r, y-kr), P(x-kr, y-r), p4)
-	path.Add3(P(x+kr, y-r), P(x+r, y-kr), p1)
+	path.Start(right)
+	path.Add3(Point{X: c.X + rx, Y: c.Y + ky}, Point{X: c.X + kx, Y: c.Y + ry}, bottom)
+	path.Add3(Point{X: c.X - kx, Y: c.Y + ry}, Point{X: c.X - rx, Y: c.Y + ky}, left)
+	path.Add3(Point{X: c.X - rx, Y: c.Y - ky}, Point{X: c.X - kx, Y: c.Y - ry}, top)
+	path.Add3(Point{X: c.X + kx, Y: c.Y - ry}, Point{X: c.X + rx, Y: c.Y - ky}, right)
 	return path
 }
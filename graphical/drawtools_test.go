@@ -0,0 +1,40 @@
+package graphical
+
+import (
+	"image"
+	"testing"
+
+	"code.google.com/p/jamslam-freetype-go/freetype/raster"
+)
+
+func TestP(t *testing.T) {
+	tests := []struct {
+		p    Point
+		want raster.Point
+	}{
+		{Point{X: 3, Y: 0}, raster.Point{X: 3 << 8, Y: 0}},
+		{Point{X: 1.5, Y: 2.25}, raster.Point{X: 384, Y: 576}},
+		{Point{X: 0.125, Y: 10.5}, raster.Point{X: 32, Y: 2688}},
+		{Point{X: -1.5, Y: -0.25}, raster.Point{X: -384, Y: -64}},
+	}
+	for _, tt := range tests {
+		if got := P(tt.p); got != tt.want {
+			t.Errorf("P(%v): got %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestFillFractional(t *testing.T) {
+	// a square covering the right half of pixel 1, pixels 2 and 3, and
+	// the left half of pixel 4 of the rows 1 to 3
+	s := NewShape(Point{X: 1.5, Y: 1}, Point{X: 4.5, Y: 1}, Point{X: 4.5, Y: 4}, Point{X: 1.5, Y: 4})
+	s.Closed = true
+	img := image.NewRGBA(image.Rect(0, 0, 6, 6))
+	Fill(img, s.MakeIntoRenderPath(Grid{CellW: 10, CellH: 14}, Options{}), Color{A: 255}.RGBA())
+	for x, want := range []int{0, 128, 255, 255, 128, 0} {
+		got := int(img.RGBAAt(x, 2).A)
+		if got < want-2 || got > want+2 {
+			t.Errorf("pixel (%d, 2): got alpha %d, want %d", x, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package graphical
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// MAX_GRID_SIZE is the largest width or height, in pixels, of a decoded
+// diagram.
+const MAX_GRID_SIZE = 1 << 16
+
+// WriteXML serializes the diagram in the XML format used by the test
+// fixtures of the original Java implementation.
+func (d *Diagram) WriteXML(w io.Writer) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(d)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// WriteJSON serializes the diagram as JSON. The structure mirrors the XML
+// format; shape and point types are the same numeric values (e.g. 0 for
+// TYPE_SIMPLE and POINT_NORMAL).
+func (d *Diagram) WriteJSON(w io.Writer) error {
+	tmp := *d
+	if tmp.Shapes == nil {
+		tmp.Shapes = []Shape{}
+	}
+	if tmp.Labels == nil {
+		tmp.Labels = []Label{}
+	}
+	buf, err := json.MarshalIndent(&tmp, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadXML decodes a diagram written by WriteXML (or by the Java
+// implementation's test fixture generator).
+func ReadXML(r io.Reader) (*Diagram, error) {
+	d := Diagram{}
+	err := xml.NewDecoder(r).Decode(&d)
+	if err != nil {
+		return nil, err
+	}
+	err = d.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ReadJSON decodes a diagram written by WriteJSON.
+func ReadJSON(r io.Reader) (*Diagram, error) {
+	d := Diagram{}
+	err := json.NewDecoder(r).Decode(&d)
+	if err != nil {
+		return nil, err
+	}
+	err = d.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// validate checks that a decoded diagram can be rendered: the cells must
+// have a size, the grid must fit in MAX_GRID_SIZE, and the shapes must
+// have enough points to be drawn.
+func (d *Diagram) validate() error {
+	g := d.Grid
+	if g.CellW <= 0 || g.CellH <= 0 {
+		return fmt.Errorf("invalid cell size %dx%d", g.CellW, g.CellH)
+	}
+	if g.W < 0 || g.H < 0 || g.W > MAX_GRID_SIZE || g.H > MAX_GRID_SIZE {
+		return fmt.Errorf("invalid grid size %dx%d, must be between 0 and %d", g.W, g.H, MAX_GRID_SIZE)
+	}
+	for i, s := range d.Shapes {
+		min := 2
+		switch {
+		case s.Type == TYPE_POINT_MARKER:
+			min = 1
+		case s.Closed:
+			min = 3
+		}
+		if len(s.Points) < min {
+			return fmt.Errorf("shape %d of type '%s' has %d points, needs at least %d", i, s.Type, len(s.Points), min)
+		}
+	}
+	return nil
+}
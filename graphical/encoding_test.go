@@ -0,0 +1,113 @@
+package graphical
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// encodingTestDiagram has a shape of every type and arrowhead style, and
+// labels with every combination of the flags.
+func encodingTestDiagram() *Diagram {
+	d := &Diagram{Grid: Grid{W: 20, H: 10, CellW: 10, CellH: 14}}
+	types := []ShapeType{TYPE_SIMPLE, TYPE_ARROWHEAD, TYPE_POINT_MARKER,
+		TYPE_DOCUMENT, TYPE_STORAGE, TYPE_IO, TYPE_DECISION,
+		TYPE_MANUAL_OPERATION, TYPE_TRAPEZOID, TYPE_ELLIPSE, TYPE_CUSTOM}
+	for i, typ := range types {
+		s := NewShape(
+			Point{X: float64(10 * i), Y: 7},
+			Point{X: float64(10*i) + 5, Y: 7, Locked: true},
+			Point{X: float64(10*i) + 5, Y: 21.5, Type: POINT_ROUND},
+		)
+		s.Type = typ
+		s.StrokeColor = Color{R: uint8(i), G: 100, B: 200, A: 255}
+		s.Closed = i%2 == 0
+		s.Dashed = i%3 == 0
+		if i%2 == 1 {
+			s.FillColor = &Color{R: 255, G: uint8(20 * i), B: 0, A: 128}
+		}
+		d.Shapes = append(d.Shapes, *s)
+	}
+	for _, style := range []ArrowheadStyle{ARROWHEAD_OPEN, ARROWHEAD_DIAMOND,
+		ARROWHEAD_HOLLOW_DIAMOND, ARROWHEAD_CIRCLE, ARROWHEAD_CROWS_FOOT} {
+		s := NewShape(Point{X: 1, Y: 2}, Point{X: 3, Y: 4}, Point{X: 5, Y: 2})
+		s.Type = TYPE_ARROWHEAD
+		s.Closed = true
+		s.Arrowhead = style
+		d.Shapes = append(d.Shapes, *s)
+	}
+	for i := 0; i < 4; i++ {
+		d.Labels = append(d.Labels, Label{
+			Text:         "label <&> \"ü\"",
+			FontSize:     12.5,
+			X:            10 * i,
+			Y:            28,
+			Color:        Color{R: 10, G: 20, B: 30, A: uint8(64 * i)},
+			OnLine:       i&1 != 0,
+			Outline:      i&2 != 0,
+			OutlineColor: Color{R: 255, G: 255, B: 255, A: 255},
+		})
+	}
+	return d
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	formats := []struct {
+		name  string
+		write func(*Diagram, io.Writer) error
+		read  func(io.Reader) (*Diagram, error)
+	}{
+		{"XML", (*Diagram).WriteXML, ReadXML},
+		{"JSON", (*Diagram).WriteJSON, ReadJSON},
+	}
+	want := encodingTestDiagram()
+	for _, f := range formats {
+		var buf bytes.Buffer
+		if err := f.write(want, &buf); err != nil {
+			t.Errorf("%s: write: %v", f.name, err)
+			continue
+		}
+		got, err := f.read(&buf)
+		if err != nil {
+			t.Errorf("%s: read: %v", f.name, err)
+			continue
+		}
+		got.XMLName = want.XMLName
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", f.name, got, want)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *Diagram)
+	}{
+		{"zero cell width", func(d *Diagram) { d.Grid.CellW = 0 }},
+		{"negative cell height", func(d *Diagram) { d.Grid.CellH = -14 }},
+		{"negative width", func(d *Diagram) { d.Grid.W = -1 }},
+		{"oversized height", func(d *Diagram) { d.Grid.H = MAX_GRID_SIZE + 1 }},
+		{"line with one point", func(d *Diagram) { d.Shapes[1].Points = d.Shapes[1].Points[:1] }},
+		{"closed shape with two points", func(d *Diagram) { d.Shapes[0].Points = d.Shapes[0].Points[:2] }},
+		{"marker without points", func(d *Diagram) { d.Shapes[2].Points = nil }},
+	}
+	for _, tt := range tests {
+		d := encodingTestDiagram()
+		tt.modify(d)
+		var xbuf, jbuf bytes.Buffer
+		if err := d.WriteXML(&xbuf); err != nil {
+			t.Fatalf("%s: write XML: %v", tt.name, err)
+		}
+		if err := d.WriteJSON(&jbuf); err != nil {
+			t.Fatalf("%s: write JSON: %v", tt.name, err)
+		}
+		if _, err := ReadXML(&xbuf); err == nil {
+			t.Errorf("%s: ReadXML: got no error", tt.name)
+		}
+		if _, err := ReadJSON(&jbuf); err == nil {
+			t.Errorf("%s: ReadJSON: got no error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package graphical
+
+import "sort"
+
+// HOP_SIZE is the radius of the hops drawn by Options.LineHops, relative
+// to the smaller of the cell dimensions.
+const HOP_SIZE float64 = 0.5
+
+// findLineHops returns, for each of the shapes, the points where its
+// horizontal sides cross a vertical side of another open shape without a
+// joint, i.e. strictly inside both sides. Only open shapes other than
+// arrowheads get hops.
+func findLineHops(shapes []Shape) [][]Point {
+	type side struct{ a, b Point }
+	sides := func(s *Shape, horizontal bool) []side {
+		result := []side{}
+		for i := 1; i < len(s.Points); i++ {
+			a, b := s.Points[i-1], s.Points[i]
+			if horizontal && a.Y == b.Y && a.X != b.X || !horizontal && a.X == b.X && a.Y != b.Y {
+				result = append(result, side{a, b})
+			}
+		}
+		return result
+	}
+	between := func(v, a, b float64) bool { return a < v && v < b || b < v && v < a }
+
+	hops := make([][]Point, len(shapes))
+	for i := range shapes {
+		s := &shapes[i]
+		if s.Closed || s.Type == TYPE_ARROWHEAD {
+			continue
+		}
+		for _, h := range sides(s, true) {
+			for j := range shapes {
+				o := &shapes[j]
+				if j == i || o.Closed || o.Type == TYPE_ARROWHEAD {
+					continue
+				}
+				for _, v := range sides(o, false) {
+					if between(v.a.X, h.a.X, h.b.X) && between(h.a.Y, v.a.Y, v.b.Y) {
+						hops[i] = append(hops[i], Point{X: v.a.X, Y: h.a.Y})
+					}
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// withHops returns the path with a semicircular hop of radius r over each
+// of the hops lying on its straight horizontal segments, at least r away
+// from their ends.
+func (p Path) withHops(hops []Point, r float64) Path {
+	if len(hops) == 0 {
+		return p
+	}
+	result := Path{}
+	var last Point
+	for _, seg := range p {
+		end := seg.End()
+		if seg.Op != PATH_LINE || end.Y != last.Y {
+			result = append(result, seg)
+			last = end
+			continue
+		}
+		dir := 1.0
+		if end.X < last.X {
+			dir = -1
+		}
+		xs := []float64{}
+		for _, h := range hops {
+			if h.Y == end.Y && (h.X-last.X)*dir >= r && (end.X-h.X)*dir >= r {
+				xs = append(xs, h.X)
+			}
+		}
+		sort.Float64s(xs)
+		if dir < 0 {
+			for i, j := 0, len(xs)-1; i < j; i, j = i+1, j-1 {
+				xs[i], xs[j] = xs[j], xs[i]
+			}
+		}
+		k := MAGIC_K * r
+		y := end.Y
+		for _, x := range xs {
+			// the hop bulges upwards, whichever way the line goes
+			result.Add1(Point{X: x - dir*r, Y: y})
+			result.Add3(Point{X: x - dir*r, Y: y - k}, Point{X: x - dir*k, Y: y - r}, Point{X: x, Y: y - r})
+			result.Add3(Point{X: x + dir*k, Y: y - r}, Point{X: x + dir*r, Y: y - k}, Point{X: x + dir*r, Y: y})
+		}
+		result = append(result, seg)
+		last = end
+	}
+	return result
+}
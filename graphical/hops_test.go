@@ -0,0 +1,33 @@
+package graphical
+
+import "testing"
+
+func TestLineHops(t *testing.T) {
+	shapes := []Shape{
+		*NewShape(Point{X: 0, Y: 50}, Point{X: 100, Y: 50}),
+		*NewShape(Point{X: 30, Y: 0}, Point{X: 30, Y: 100}),
+		// ends on the horizontal line: a joint, not a crossing
+		*NewShape(Point{X: 60, Y: 0}, Point{X: 60, Y: 50}),
+	}
+	hops := findLineHops(shapes)
+	if len(hops[0]) != 1 || hops[0][0] != (Point{X: 30, Y: 50}) || len(hops[1]) != 0 || len(hops[2]) != 0 {
+		t.Fatalf("got hops %v, want only (30, 50) on the first line", hops)
+	}
+	path := shapes[0].MakeIntoOutline(Grid{CellW: 10, CellH: 14}, Options{}).withHops(hops[0], 5)
+	ops := []PathOp{}
+	for _, seg := range path {
+		ops = append(ops, seg.Op)
+	}
+	want := []PathOp{PATH_START, PATH_LINE, PATH_CUBIC, PATH_CUBIC, PATH_LINE}
+	if len(ops) != len(want) {
+		t.Fatalf("got path %v, want a hop", path)
+	}
+	for i := range ops {
+		if ops[i] != want[i] {
+			t.Fatalf("got path %v, want a hop", path)
+		}
+	}
+	if top := path[2].End(); top != (Point{X: 30, Y: 45}) {
+		t.Errorf("hop reaches %v, want (30, 45)", top)
+	}
+}
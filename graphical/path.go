@@ -0,0 +1,68 @@
+package graphical
+
+import (
+	"code.google.com/p/jamslam-freetype-go/freetype/raster"
+)
+
+type PathOp int
+
+const (
+	PATH_START PathOp = iota
+	PATH_LINE
+	PATH_QUAD  // quadratic Bézier curve
+	PATH_CUBIC // cubic Bézier curve
+)
+
+// PathSegment is a single step of a Path. Points holds the control
+// points of a curve (if any), followed by the end point of the segment.
+type PathSegment struct {
+	Op     PathOp
+	Points []Point
+}
+
+// End returns the point where the segment ends.
+func (s PathSegment) End() Point { return s.Points[len(s.Points)-1] }
+
+// Path is an outline independent of the drawing backend, built with the
+// same methods as raster.Path.
+type Path []PathSegment
+
+func (p *Path) Start(a Point)      { *p = append(*p, PathSegment{PATH_START, []Point{a}}) }
+func (p *Path) Add1(b Point)       { *p = append(*p, PathSegment{PATH_LINE, []Point{b}}) }
+func (p *Path) Add2(b, c Point)    { *p = append(*p, PathSegment{PATH_QUAD, []Point{b, c}}) }
+func (p *Path) Add3(b, c, d Point) { *p = append(*p, PathSegment{PATH_CUBIC, []Point{b, c, d}}) }
+
+// Raster converts the path for the freetype rasterizer. Cubic curves are
+// approximated with straight segments, because its stroker doesn't
+// support them.
+func (p Path) Raster() raster.Path {
+	if p == nil {
+		return nil
+	}
+	path := raster.Path{}
+	var last Point
+	for _, seg := range p {
+		pts := seg.Points
+		switch seg.Op {
+		case PATH_START:
+			path.Start(P(pts[0]))
+		case PATH_LINE:
+			path.Add1(P(pts[0]))
+		case PATH_QUAD:
+			path.Add2(P(pts[0]), P(pts[1]))
+		case PATH_CUBIC:
+			const steps = 16
+			for i := 1; i <= steps; i++ {
+				t := float64(i) / steps
+				u := 1 - t
+				a, b, c, d := u*u*u, 3*u*u*t, 3*u*t*t, t*t*t
+				path.Add1(raster.Point{
+					X: ftofix(a*last.X + b*pts[0].X + c*pts[1].X + d*pts[2].X),
+					Y: ftofix(a*last.Y + b*pts[0].Y + c*pts[1].Y + d*pts[2].Y),
+				})
+			}
+		}
+		last = seg.End()
+	}
+	return path
+}
@@ -0,0 +1,47 @@
+package graphical
+
+import (
+	"reflect"
+	"testing"
+
+	"code.google.com/p/jamslam-freetype-go/freetype/raster"
+)
+
+func TestPathRaster(t *testing.T) {
+	if got := Path(nil).Raster(); got != nil {
+		t.Errorf("nil path: got %v, want nil", got)
+	}
+
+	path := Path{}
+	path.Start(Point{X: 10, Y: 0})
+	path.Add1(Point{X: 0, Y: 0})
+	path.Add3(Point{X: 0, Y: 8}, Point{X: 8, Y: 8}, Point{X: 8, Y: 0})
+	path.Add2(Point{X: 8, Y: -4}, Point{X: 10, Y: 0})
+	got := path.Raster()
+
+	line := func(p Point) raster.Path {
+		r := raster.Path{}
+		r.Add1(P(p))
+		return r
+	}
+	want := raster.Path{}
+	want.Start(P(Point{X: 10, Y: 0}))
+	want.Add1(P(Point{X: 0, Y: 0}))
+	if len(got) < len(want) || !reflect.DeepEqual(got[:len(want)], want) {
+		t.Fatalf("start and line: got %v, want %v", got, want)
+	}
+	// the cubic curve is approximated with 16 straight segments
+	n := len(line(Point{}))
+	curve := got[len(want) : len(want)+16*n]
+	if mid, want := curve[7*n:8*n], line(Point{X: 4, Y: 6}); !reflect.DeepEqual(mid, want) {
+		t.Errorf("middle of the curve: got %v, want %v", mid, want)
+	}
+	if end, want := curve[15*n:], line(Point{X: 8, Y: 0}); !reflect.DeepEqual(end, want) {
+		t.Errorf("end of the curve: got %v, want %v", end, want)
+	}
+	quad := raster.Path{}
+	quad.Add2(P(Point{X: 8, Y: -4}), P(Point{X: 10, Y: 0}))
+	if rest := got[len(want)+16*n:]; !reflect.DeepEqual(rest, quad) {
+		t.Errorf("quadratic curve: got %v, want %v", rest, quad)
+	}
+}
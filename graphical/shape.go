@@ -9,10 +9,10 @@ import (
 )
 
 type Grid struct {
-	W     int `xml:"width"`
-	H     int `xml:"height"`
-	CellW int `xml:"cellWidth"`
-	CellH int `xml:"cellHeight"`
+	W     int `xml:"width" json:"width"`
+	H     int `xml:"height" json:"height"`
+	CellW int `xml:"cellWidth" json:"cellWidth"`
+	CellH int `xml:"cellHeight" json:"cellHeight"`
 }
 
 type Cell struct {
@@ -48,13 +48,36 @@ const (
 	TYPE_CUSTOM ShapeType = 9999
 )
 
+var shapeTypeNames = map[ShapeType]string{
+	TYPE_SIMPLE:           "simple",
+	TYPE_ARROWHEAD:        "arrowhead",
+	TYPE_POINT_MARKER:     "point-marker",
+	TYPE_DOCUMENT:         "document",
+	TYPE_STORAGE:          "storage",
+	TYPE_IO:               "io",
+	TYPE_DECISION:         "decision",
+	TYPE_MANUAL_OPERATION: "manual-operation",
+	TYPE_TRAPEZOID:        "trapezoid",
+	TYPE_ELLIPSE:          "ellipse",
+	TYPE_CUSTOM:           "custom",
+}
+
+func (t ShapeType) String() string {
+	if name, ok := shapeTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ShapeType(%d)", int(t))
+}
+
 type Shape struct {
-	Type        ShapeType `xml:"type"`
-	FillColor   *Color    `xml:"fillColor"`
-	StrokeColor Color     `xml:"strokeColor"`
-	Closed      bool      `xml:"isClosed"`
-	Dashed      bool      `xml:"isStrokeDashed"`
-	Points      []Point   `xml:"points>point"`
+	Type        ShapeType `xml:"type" json:"type"`
+	FillColor   *Color    `xml:"fillColor" json:"fillColor,omitempty"`
+	StrokeColor Color     `xml:"strokeColor" json:"strokeColor"`
+	Closed      bool      `xml:"isClosed" json:"closed"`
+	Dashed      bool      `xml:"isStrokeDashed" json:"dashed"`
+	Points      []Point   `xml:"points>point" json:"points"`
+	// Arrowhead is the style of a shape of TYPE_ARROWHEAD.
+	Arrowhead ArrowheadStyle `xml:"arrowhead,omitempty" json:"arrowhead,omitempty"`
 }
 
 func NewShape(points ...Point) *Shape {
@@ -65,6 +88,10 @@ func NewShape(points ...Point) *Shape {
 }
 
 func (s *Shape) CalcArea() float64 {
+	if s.isEllipse() {
+		_, rx, ry := s.ellipse()
+		return math.Pi * rx * ry
+	}
 	// See http://mathworld.wolfram.com/PolygonArea.html
 	if len(s.Points) == 0 {
 		return 0
@@ -82,14 +109,14 @@ func (s *Shape) DropsShadow() bool {
 	return s.Closed && s.Type != TYPE_ARROWHEAD && s.Type != TYPE_POINT_MARKER && !s.Dashed
 }
 
-func (s *Shape) MakeMarkerPaths(g Grid) (outer, inner raster.Path) {
+func (s *Shape) MakeMarkerPaths(g Grid, t *Theme) (outer, inner raster.Path) {
 	if len(s.Points) != 1 {
 		return nil, nil
 	}
 	center := s.Points[0]
-	diameter := 0.7 * math.Min(float64(g.CellW), float64(g.CellH))
-	return Circle(float64(center.X), float64(center.Y), (diameter+STROKE_WIDTH)*0.5),
-		Circle(float64(center.X), float64(center.Y), (diameter-STROKE_WIDTH)*0.5)
+	diameter := t.MarkerSize * math.Min(float64(g.CellW), float64(g.CellH))
+	return Circle(float64(center.X), float64(center.Y), (diameter+t.StrokeWidth)*0.5),
+		Circle(float64(center.X), float64(center.Y), (diameter-t.StrokeWidth)*0.5)
 }
 
 func (s *Shape) MakeIntoPath() polyclip.Contour {
@@ -105,15 +132,31 @@ func (s *Shape) MakeIntoPath() polyclip.Contour {
 }
 
 func (s Shape) Contains(p Point) bool {
+	if s.isEllipse() {
+		c, rx, ry := s.ellipse()
+		if rx == 0 || ry == 0 {
+			return false
+		}
+		dx, dy := (p.X-c.X)/rx, (p.Y-c.Y)/ry
+		return dx*dx+dy*dy <= 1
+	}
 	path := s.MakeIntoPath()
 	if path == nil {
 		return false
 	}
-	return path.Contains(polyclip.Point{p.X, p.Y})
+	return path.Contains(polyclip.Point{X: p.X, Y: p.Y})
 }
 
 // FIXME(akavel): buggy, fix this... use full polyclip.Construct()
 func (s Shape) Intersects(rect Rect) bool {
+	if s.isEllipse() {
+		// the point of rect nearest to the center
+		c, _, _ := s.ellipse()
+		return s.Contains(Point{
+			X: math.Max(rect.Min.X, math.Min(c.X, rect.Max.X)),
+			Y: math.Max(rect.Min.Y, math.Min(c.Y, rect.Max.Y)),
+		})
+	}
 	for _, p := range s.Points {
 		if rect.Contains(p) {
 			return true
@@ -195,49 +238,60 @@ func specPoints(bb Rect) (p1, p2, p3, p4 Point) {
 	return
 }
 
-func (s *Shape) makeDocumentPath() raster.Path {
+func (s *Shape) makeDocumentPath() Path {
 	bb := Bounds(s.Points)
 	p1, p2, p3, p4 := specPoints(bb)
 	pmid := Point{X: 0.5 * (bb.Min.X + bb.Max.X), Y: bb.Max.Y}
 
-	path := raster.Path{}
-	path.Start(P(p1))
-	path.Add1(P(p2))
-	path.Add1(P(p3))
+	path := Path{}
+	path.Start(p1)
+	path.Add1(p2)
+	path.Add1(p3)
 
 	controlDX := (bb.Max.X - bb.Min.X) / 6
 	controlDY := (bb.Max.Y - bb.Min.Y) / 8
-	path.Add2(P(Point{X: pmid.X + controlDX, Y: pmid.Y - controlDY}), P(pmid))
-	path.Add2(P(Point{X: pmid.X - controlDX, Y: pmid.Y + controlDY}), P(p4))
-	path.Add1(P(p1))
+	path.Add2(Point{X: pmid.X + controlDX, Y: pmid.Y - controlDY}, pmid)
+	path.Add2(Point{X: pmid.X - controlDX, Y: pmid.Y + controlDY}, p4)
+	path.Add1(p1)
 	return path
 }
 
-func (s *Shape) makeIOPath(g Grid /*, opt Options*/) raster.Path {
+// SHAPE_SLOPE is the ratio of the height of IO and trapezoid shapes to
+// the horizontal offset of their sides, if Options.FixedSlope is set.
+const SHAPE_SLOPE float64 = 8
+
+// slopeOffset returns the horizontal offset of the sides of IO and
+// trapezoid shapes with the bounds bb.
+func slopeOffset(bb Rect, g Grid, opt Options) float64 {
+	if opt.FixedSlope {
+		return (bb.Max.Y - bb.Min.Y) / SHAPE_SLOPE
+	}
+	return float64(g.CellW) * 0.5
+}
+
+func (s *Shape) makeIOPath(g Grid, opt Options) Path {
 	if len(s.Points) != 4 {
 		return nil
 	}
 	bb := Bounds(s.Points)
 	p1, p2, p3, p4 := specPoints(bb)
-	//TODO: handle opt.FixedSlope
-	offset := float64(g.CellW) * 0.5
-
-	path := raster.Path{}
-	path.Start(P(Point{X: p1.X + offset, Y: p1.Y}))
-	path.Add1(P(Point{X: p2.X + offset, Y: p2.Y}))
-	path.Add1(P(Point{X: p3.X - offset, Y: p3.Y}))
-	path.Add1(P(Point{X: p4.X - offset, Y: p4.Y}))
-	path.Add1(P(Point{X: p1.X + offset, Y: p1.Y})) // close path
+	offset := slopeOffset(bb, g, opt)
+
+	path := Path{}
+	path.Start(Point{X: p1.X + offset, Y: p1.Y})
+	path.Add1(Point{X: p2.X + offset, Y: p2.Y})
+	path.Add1(Point{X: p3.X - offset, Y: p3.Y})
+	path.Add1(Point{X: p4.X - offset, Y: p4.Y})
+	path.Add1(Point{X: p1.X + offset, Y: p1.Y}) // close path
 	return path
 }
 
-func (s *Shape) makeTrapezoidPath(g Grid /*, opt Options*/, inverted bool) raster.Path {
+func (s *Shape) makeTrapezoidPath(g Grid, opt Options, inverted bool) Path {
 	if len(s.Points) != 4 {
 		return nil
 	}
 	bb := Bounds(s.Points)
-	//TODO: handle opt.FixedSlope
-	offset := float64(g.CellW) * 0.5
+	offset := slopeOffset(bb, g, opt)
 	if inverted {
 		offset = -offset
 	}
@@ -247,16 +301,16 @@ func (s *Shape) makeTrapezoidPath(g Grid /*, opt Options*/, inverted bool) raste
 	bl := Point{X: bb.Min.X - offset, Y: bb.Max.Y}
 	//pmid := Point{X:0.5*(bb.Min.X+bb.Max.X), Y:bb.Max.Y}
 
-	path := raster.Path{}
-	path.Start(P(ul))
-	path.Add1(P(ur))
-	path.Add1(P(br))
-	path.Add1(P(bl))
-	path.Add1(P(ul)) // close path
+	path := Path{}
+	path.Start(ul)
+	path.Add1(ur)
+	path.Add1(br)
+	path.Add1(bl)
+	path.Add1(ul) // close path
 	return path
 }
 
-func (s *Shape) makeDecisionPath() raster.Path {
+func (s *Shape) makeDecisionPath() Path {
 	if len(s.Points) != 4 {
 		return nil
 	}
@@ -267,16 +321,38 @@ func (s *Shape) makeDecisionPath() raster.Path {
 	top := Point{X: pmid.X, Y: bb.Min.Y}
 	bottom := Point{X: pmid.X, Y: bb.Max.Y}
 
-	path := raster.Path{}
-	path.Start(P(left))
-	path.Add1(P(top))
-	path.Add1(P(right))
-	path.Add1(P(bottom))
-	path.Add1(P(left)) // close path
+	path := Path{}
+	path.Start(left)
+	path.Add1(top)
+	path.Add1(right)
+	path.Add1(bottom)
+	path.Add1(left) // close path
 	return path
 }
 
-func (s *Shape) makeStoragePath(g Grid) raster.Path {
+// isEllipse tells if the shape is drawn as an ellipse instead of its
+// points.
+func (s *Shape) isEllipse() bool {
+	return s.Type == TYPE_ELLIPSE && len(s.Points) == 4
+}
+
+// ellipse returns the center and the radii of the ellipse fitting the
+// bounds of the shape.
+func (s *Shape) ellipse() (c Point, rx, ry float64) {
+	bb := Bounds(s.Points)
+	c = Point{X: 0.5 * (bb.Min.X + bb.Max.X), Y: 0.5 * (bb.Min.Y + bb.Max.Y)}
+	return c, 0.5 * (bb.Max.X - bb.Min.X), 0.5 * (bb.Max.Y - bb.Min.Y)
+}
+
+func (s *Shape) makeEllipsePath() Path {
+	if len(s.Points) != 4 {
+		return nil
+	}
+	c, rx, ry := s.ellipse()
+	return Ellipse(c, rx, ry)
+}
+
+func (s *Shape) makeStoragePath(g Grid) Path {
 	if len(s.Points) != 4 {
 		return nil
 	}
@@ -288,15 +364,15 @@ func (s *Shape) makeStoragePath(g Grid) raster.Path {
 	offytop := float64(g.CellH) / 2
 	offybottom := float64(g.CellH) * 10 / 14
 
-	path := raster.Path{}
+	path := Path{}
 	//top of cylinder
-	path.Start(P(p1))
-	path.Add3(P(Point{X: p1.X + offx, Y: p1.Y + offytop}), P(Point{X: p2.X - offx, Y: p2.Y + offytop}), P(p2))
-	path.Add3(P(Point{X: p2.X - offx, Y: p2.Y - offytop}), P(Point{X: p1.X + offx, Y: p1.Y - offytop}), P(p1))
+	path.Start(p1)
+	path.Add3(Point{X: p1.X + offx, Y: p1.Y + offytop}, Point{X: p2.X - offx, Y: p2.Y + offytop}, p2)
+	path.Add3(Point{X: p2.X - offx, Y: p2.Y - offytop}, Point{X: p1.X + offx, Y: p1.Y - offytop}, p1)
 	//side of cylinder
-	path.Add1(P(p4))
-	path.Add3(P(Point{X: p4.X + offx, Y: p4.Y + offybottom}), P(Point{X: p3.X - offx, Y: p3.Y + offybottom}), P(p3))
-	path.Add1(P(p2))
+	path.Add1(p4)
+	path.Add3(Point{X: p4.X + offx, Y: p4.Y + offybottom}, Point{X: p3.X - offx, Y: p3.Y + offybottom}, p3)
+	path.Add1(p2)
 	return path
 }
 
@@ -318,50 +394,54 @@ func getCellEdgePointBetween(pointInCell, otherPoint Point, g Grid) Point {
 	panic("should not reach")
 }
 
-func (s *Shape) MakeIntoRenderPath(g Grid /*, opt Options*/) raster.Path {
+func (s *Shape) MakeIntoRenderPath(g Grid, opt Options) raster.Path {
+	return s.MakeIntoOutline(g, opt).Raster()
+}
+
+// MakeIntoOutline returns the outline of the shape as drawn on the grid g,
+// with the corners rounded and the curves of the special shape types.
+// Point markers have no outline, they are drawn with MakeMarkerPaths.
+func (s *Shape) MakeIntoOutline(g Grid, opt Options) Path {
 	if s.Type == TYPE_POINT_MARKER {
 		panic("please handle markers separately")
-		return nil
-		//return s.makeMarkerPath(g)
+	}
+	if s.Type == TYPE_ARROWHEAD && s.Arrowhead != ARROWHEAD_FILLED {
+		return s.makeArrowheadPath(g, opt)
 	}
 	if len(s.Points) == 4 {
 		switch s.Type {
 		case TYPE_DOCUMENT:
 			return s.makeDocumentPath()
 		case TYPE_IO:
-			return s.makeIOPath(g /*, opt*/)
+			return s.makeIOPath(g, opt)
 		case TYPE_MANUAL_OPERATION:
-			return s.makeTrapezoidPath(g /*, opt*/, true)
+			return s.makeTrapezoidPath(g, opt, true)
 		case TYPE_TRAPEZOID:
-			return s.makeTrapezoidPath(g /*, opt*/, false)
+			return s.makeTrapezoidPath(g, opt, false)
 		case TYPE_DECISION:
 			return s.makeDecisionPath()
-		//case TYPE_STORAGE:
-		//	return s.makeStoragePath(g)
-		case TYPE_STORAGE, TYPE_ELLIPSE:
-			_ = fmt.Sprintf
-			//panic(fmt.Sprintf("niy for type %d", s.Type))
-			//TODO: fixme
-			return nil
+		case TYPE_STORAGE:
+			return s.makeStoragePath(g)
+		case TYPE_ELLIPSE:
+			return s.makeEllipsePath()
 		}
 	}
-	return s.makeOtherPath(g)
+	return s.makeOtherPath(g, opt)
 }
 
-func (s *Shape) makeOtherPath(g Grid) raster.Path {
+func (s *Shape) makeOtherPath(g Grid, opt Options) Path {
 	if len(s.Points) < 2 {
 		return nil
 	}
-	path := raster.Path{}
+	path := Path{}
 	point, prev, next := s.Points[0], s.Points[len(s.Points)-1], s.Points[1]
 	switch point.Type {
 	case POINT_NORMAL:
-		path.Start(P(point))
+		path.Start(point)
 	case POINT_ROUND:
-		entry := getCellEdgePointBetween(point, prev, g)
-		exit := getCellEdgePointBetween(point, next, g)
-		path.Start(P(entry))
-		path.Add2(P(point), P(exit))
+		entry, exit := roundCornerEnds(point, prev, next, g, opt)
+		path.Start(entry)
+		addRoundCorner(&path, entry, point, exit, opt)
 	}
 	for i := 1; i < len(s.Points); i++ {
 		prev = point
@@ -373,12 +453,11 @@ func (s *Shape) makeOtherPath(g Grid) raster.Path {
 		}
 		switch point.Type {
 		case POINT_NORMAL:
-			path.Add1(P(point))
+			path.Add1(point)
 		case POINT_ROUND:
-			entry := getCellEdgePointBetween(point, prev, g)
-			exit := getCellEdgePointBetween(point, next, g)
-			path.Add1(P(entry))
-			path.Add2(P(point), P(exit))
+			entry, exit := roundCornerEnds(point, prev, next, g, opt)
+			path.Add1(entry)
+			addRoundCorner(&path, entry, point, exit, opt)
 		}
 	}
 	if s.Closed && len(s.Points) > 2 {
@@ -386,11 +465,44 @@ func (s *Shape) makeOtherPath(g Grid) raster.Path {
 		point = s.Points[0]
 		switch point.Type {
 		case POINT_NORMAL:
-			path.Add1(P(point))
+			path.Add1(point)
 		case POINT_ROUND:
-			entry := getCellEdgePointBetween(point, prev, g)
-			path.Add1(P(entry))
+			entry, _ := roundCornerEnds(point, prev, s.Points[1], g, opt)
+			path.Add1(entry)
 		}
 	}
 	return path
 }
+
+// roundCornerEnds returns where the round corner at point begins, coming
+// from prev, and ends, going to next: at the edges of its cell, or
+// opt.CornerRadius away from it, but no further than half of either side.
+func roundCornerEnds(point, prev, next Point, g Grid, opt Options) (entry, exit Point) {
+	if opt.CornerRadius <= 0 {
+		return getCellEdgePointBetween(point, prev, g), getCellEdgePointBetween(point, next, g)
+	}
+	dprev := math.Hypot(prev.X-point.X, prev.Y-point.Y)
+	dnext := math.Hypot(next.X-point.X, next.Y-point.Y)
+	r := math.Min(opt.CornerRadius, math.Min(dprev, dnext)/2)
+	towards := func(other Point, d float64) Point {
+		if d == 0 {
+			return point
+		}
+		return Point{X: point.X + (other.X-point.X)/d*r, Y: point.Y + (other.Y-point.Y)/d*r}
+	}
+	return towards(prev, dprev), towards(next, dnext)
+}
+
+// addRoundCorner adds the curve of a round corner at point, from entry to
+// exit: a quadratic one by default, or a circular arc if opt sets the
+// radius of the corners.
+func addRoundCorner(path *Path, entry, point, exit Point, opt Options) {
+	if opt.CornerRadius <= 0 {
+		path.Add2(point, exit)
+		return
+	}
+	path.Add3(
+		Point{X: entry.X + (point.X-entry.X)*MAGIC_K, Y: entry.Y + (point.Y-entry.Y)*MAGIC_K},
+		Point{X: exit.X + (point.X-exit.X)*MAGIC_K, Y: exit.Y + (point.Y-exit.Y)*MAGIC_K},
+		exit)
+}
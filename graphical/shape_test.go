@@ -0,0 +1,53 @@
+package graphical
+
+import "testing"
+
+func TestFixedSlope(t *testing.T) {
+	g := Grid{CellW: 10, CellH: 14}
+	tests := []struct {
+		typ    ShapeType
+		height float64
+		opt    Options
+		want   float64 // horizontal offset of the top left corner
+	}{
+		{TYPE_IO, 28, Options{}, 5},
+		{TYPE_IO, 112, Options{}, 5},
+		{TYPE_IO, 28, Options{FixedSlope: true}, 3.5},
+		{TYPE_IO, 112, Options{FixedSlope: true}, 14},
+		{TYPE_TRAPEZOID, 112, Options{FixedSlope: true}, 14},
+		{TYPE_MANUAL_OPERATION, 112, Options{FixedSlope: true}, -14},
+	}
+	for _, tt := range tests {
+		s := NewShape(Point{X: 0, Y: 0}, Point{X: 100, Y: 0}, Point{X: 100, Y: tt.height}, Point{X: 0, Y: tt.height})
+		s.Type = tt.typ
+		path := s.MakeIntoOutline(g, tt.opt)
+		if len(path) == 0 {
+			t.Errorf("%s, height %g, %+v: no outline", tt.typ, tt.height, tt.opt)
+			continue
+		}
+		if got := path[0].End(); got.X != tt.want || got.Y != 0 {
+			t.Errorf("%s, height %g, %+v: outline starts at %v, want (%g, 0)", tt.typ, tt.height, tt.opt, got, tt.want)
+		}
+	}
+}
+
+func TestCornerRadius(t *testing.T) {
+	g := Grid{CellW: 10, CellH: 14}
+	corner := Point{X: 5, Y: 7, Type: POINT_ROUND}
+	s := NewShape(corner, Point{X: 105, Y: 7}, Point{X: 105, Y: 35}, Point{X: 5, Y: 35})
+	tests := []struct {
+		radius float64
+		want   Point // where the outline starts, below the corner
+	}{
+		{0, Point{X: 5, Y: 14}},
+		{6, Point{X: 5, Y: 13}},
+		// no more than half of the shorter side
+		{100, Point{X: 5, Y: 21}},
+	}
+	for _, tt := range tests {
+		path := s.MakeIntoOutline(g, Options{CornerRadius: tt.radius})
+		if got := path[0].End(); got != tt.want {
+			t.Errorf("radius %g: outline starts at %v, want %v", tt.radius, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package graphical
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The largest sizes accepted by LoadTheme. The blur of the shadows takes
+// 256*(ShadowBlur+1)² bytes of memory.
+const (
+	MAX_SHADOW_BLUR  = 64
+	MAX_STROKE_WIDTH = 16
+	MAX_MARKER_SIZE  = 1
+)
+
+// Theme is a set of the colors and sizes diagrams are drawn with. The
+// background, shadows and sizes are used when rendering; the foreground,
+// the inverse text color and the palette of the named color codes (e.g.
+// cRED) are used when interpreting the text.
+type Theme struct {
+	// Background is the color of the image, and the fill color of the
+	// shapes without a color code.
+	Background Color
+	// Foreground is the color of the lines, arrowheads and text.
+	Foreground Color
+	// InverseText is the color of the text on the shapes which are dark
+	// on a light background, or light on a dark one.
+	InverseText Color
+	Shadow      Color
+	// ShadowBlur is the radius of the blur of the shadows, in pixels.
+	ShadowBlur  int
+	StrokeWidth float64
+	// MarkerSize is the diameter of the point markers, relative to the
+	// smaller of the cell dimensions.
+	MarkerSize float64
+	// Palette maps the names of the color codes, three capital letters
+	// each, to their colors. A name made of the letters A to F hides the
+	// hex code written the same.
+	Palette map[string]Color
+}
+
+var classicTheme = Theme{
+	Background:  WHITE,
+	Foreground:  Color{A: 255},
+	InverseText: WHITE,
+	Shadow:      Color{150, 150, 150, 255},
+	ShadowBlur:  4,
+	StrokeWidth: STROKE_WIDTH,
+	MarkerSize:  0.7,
+	Palette: map[string]Color{
+		"GRE": {0x99, 0xdd, 0x99, 255},
+		"BLU": {0x55, 0x55, 0xbb, 255},
+		"PNK": {0xff, 0xaa, 0xaa, 255},
+		"RED": {0xee, 0x33, 0x22, 255},
+		"YEL": {0xff, 0xff, 0x33, 255},
+		"BLK": {0x00, 0x00, 0x00, 255},
+	},
+}
+
+// Themes are the built-in themes, by name.
+var Themes = map[string]*Theme{
+	"classic": &classicTheme,
+	"dark": {
+		Background:  Color{0x1e, 0x1e, 0x1e, 255},
+		Foreground:  Color{0xdd, 0xdd, 0xdd, 255},
+		InverseText: Color{0x1e, 0x1e, 0x1e, 255},
+		Shadow:      Color{0x00, 0x00, 0x00, 255},
+		ShadowBlur:  4,
+		StrokeWidth: STROKE_WIDTH,
+		MarkerSize:  0.7,
+		Palette: map[string]Color{
+			"GRE": {0x2e, 0x7d, 0x32, 255},
+			"BLU": {0x28, 0x4b, 0x9e, 255},
+			"PNK": {0xa0, 0x4a, 0x6a, 255},
+			"RED": {0xb7, 0x1c, 0x1c, 255},
+			"YEL": {0x9e, 0x8a, 0x10, 255},
+			"BLK": {0x00, 0x00, 0x00, 255},
+		},
+	},
+	"mono": {
+		Background:  WHITE,
+		Foreground:  Color{A: 255},
+		InverseText: WHITE,
+		Shadow:      Color{150, 150, 150, 255},
+		ShadowBlur:  4,
+		StrokeWidth: STROKE_WIDTH,
+		MarkerSize:  0.7,
+		Palette: map[string]Color{
+			"GRE": {0xcc, 0xcc, 0xcc, 255},
+			"BLU": {0x55, 0x55, 0x55, 255},
+			"PNK": {0xee, 0xee, 0xee, 255},
+			"RED": {0x77, 0x77, 0x77, 255},
+			"YEL": {0xf8, 0xf8, 0xf8, 255},
+			"BLK": {0x00, 0x00, 0x00, 255},
+		},
+	},
+	"blueprint": {
+		Background:  Color{0x1f, 0x4e, 0x8c, 255},
+		Foreground:  WHITE,
+		InverseText: Color{0x0b, 0x25, 0x4a, 255},
+		Shadow:      Color{0x0b, 0x25, 0x4a, 255},
+		ShadowBlur:  2,
+		StrokeWidth: 1.5,
+		MarkerSize:  0.6,
+		Palette: map[string]Color{
+			"GRE": {0x2f, 0x7a, 0x6a, 255},
+			"BLU": {0x2a, 0x62, 0xac, 255},
+			"PNK": {0x8a, 0x5a, 0x9c, 255},
+			"RED": {0xa8, 0x3a, 0x3a, 255},
+			"YEL": {0xb0, 0x9a, 0x3a, 255},
+			"BLK": {0x0b, 0x25, 0x4a, 255},
+		},
+	},
+}
+
+// DefaultTheme returns the "classic" theme, the colors of the original
+// ditaa.
+func DefaultTheme() *Theme { return &classicTheme }
+
+// ThemeNames returns the names of the built-in themes, sorted.
+func ThemeNames() []string {
+	names := []string{}
+	for name := range Themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NameOf returns the name of the color in the palette, if it has one.
+func (t *Theme) NameOf(c Color) (string, bool) {
+	names := []string{}
+	for name, pc := range t.Palette {
+		if pc == c {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	return names[0], true
+}
+
+/*
+LoadTheme reads a theme from JSON like the following, where all the fields
+are optional: the missing ones are taken from the built-in theme named by
+"base" ("classic" by default), and the palette is added to its palette.
+The shadowBlur may be 0 for no blur, up to MAX_SHADOW_BLUR; the
+strokeWidth and markerSize must be positive, up to MAX_STROKE_WIDTH and
+MAX_MARKER_SIZE.
+
+	{
+	  "base": "classic",
+	  "background": "#ffffff",
+	  "foreground": "#000000",
+	  "inverseText": "#ffffff",
+	  "shadow": "#969696",
+	  "shadowBlur": 4,
+	  "strokeWidth": 1,
+	  "markerSize": 0.7,
+	  "palette": {"RED": "#ee3322", "ACM": "#1e90ff"}
+	}
+*/
+func LoadTheme(r io.Reader) (*Theme, error) {
+	file := struct {
+		Base        string            `json:"base"`
+		Background  string            `json:"background"`
+		Foreground  string            `json:"foreground"`
+		InverseText string            `json:"inverseText"`
+		Shadow      string            `json:"shadow"`
+		ShadowBlur  *int              `json:"shadowBlur"`
+		StrokeWidth *float64          `json:"strokeWidth"`
+		MarkerSize  *float64          `json:"markerSize"`
+		Palette     map[string]string `json:"palette"`
+	}{Base: "classic"}
+	err := json.NewDecoder(r).Decode(&file)
+	if err != nil {
+		return nil, err
+	}
+	base, ok := Themes[file.Base]
+	if !ok {
+		return nil, fmt.Errorf("unknown base theme '%s'", file.Base)
+	}
+	t := *base
+	for _, field := range []struct {
+		name, value string
+		dst         *Color
+	}{
+		{"background", file.Background, &t.Background},
+		{"foreground", file.Foreground, &t.Foreground},
+		{"inverseText", file.InverseText, &t.InverseText},
+		{"shadow", file.Shadow, &t.Shadow},
+	} {
+		if field.value == "" {
+			continue
+		}
+		*field.dst, err = ParseColor(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s color '%s': %s", field.name, field.value, err)
+		}
+	}
+	if file.ShadowBlur != nil {
+		if *file.ShadowBlur < 0 || *file.ShadowBlur > MAX_SHADOW_BLUR {
+			return nil, fmt.Errorf("bad shadowBlur %d: must be between 0 and %d", *file.ShadowBlur, MAX_SHADOW_BLUR)
+		}
+		t.ShadowBlur = *file.ShadowBlur
+	}
+	for _, field := range []struct {
+		name  string
+		value *float64
+		max   float64
+		dst   *float64
+	}{
+		{"strokeWidth", file.StrokeWidth, MAX_STROKE_WIDTH, &t.StrokeWidth},
+		{"markerSize", file.MarkerSize, MAX_MARKER_SIZE, &t.MarkerSize},
+	} {
+		if field.value == nil {
+			continue
+		}
+		if !(*field.value > 0 && *field.value <= field.max) {
+			return nil, fmt.Errorf("bad %s %g: must be greater than 0 and at most %g", field.name, *field.value, field.max)
+		}
+		*field.dst = *field.value
+	}
+	colors := map[string]Color{}
+	for name, value := range file.Palette {
+		colors[name], err = ParseColor(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad palette color '%s': %s", name, err)
+		}
+	}
+	return t.WithColors(colors)
+}
+
+// WithColors returns a copy of the theme with the colors added to its
+// palette.
+func (t Theme) WithColors(colors map[string]Color) (*Theme, error) {
+	palette := map[string]Color{}
+	for name, c := range t.Palette {
+		palette[name] = c
+	}
+	for name, c := range colors {
+		if !isPaletteName(name) {
+			return nil, fmt.Errorf("bad palette color name '%s': must be three capital letters", name)
+		}
+		palette[name] = c
+	}
+	t.Palette = palette
+	return &t, nil
+}
+
+func isPaletteName(name string) bool {
+	if len(name) != 3 {
+		return false
+	}
+	for _, ch := range name {
+		if ch < 'A' || ch > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseColor parses a color written as "#rgb", "#rrggbb" or "#rrggbbaa".
+func ParseColor(s string) (Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if hex == s || len(hex) != 3 && len(hex) != 6 && len(hex) != 8 {
+		return Color{}, fmt.Errorf("want #rgb, #rrggbb or #rrggbbaa")
+	}
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("bad hex digits")
+	}
+	return Color{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
@@ -0,0 +1,69 @@
+package graphical
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadTheme(t *testing.T) {
+	theme, err := LoadTheme(strings.NewReader(`{
+		"base": "dark",
+		"foreground": "#eee",
+		"strokeWidth": 2,
+		"palette": {"ACM": "#1e90ff80"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Color{0xee, 0xee, 0xee, 255}); theme.Foreground != want {
+		t.Errorf("got foreground %v, want %v", theme.Foreground, want)
+	}
+	if theme.Background != Themes["dark"].Background || theme.StrokeWidth != 2 {
+		t.Errorf("got background %v and stroke width %g, want the dark background and 2", theme.Background, theme.StrokeWidth)
+	}
+	if want := (Color{0x1e, 0x90, 0xff, 0x80}); theme.Palette["ACM"] != want {
+		t.Errorf("got ACM %v, want %v", theme.Palette["ACM"], want)
+	}
+	if theme.Palette["RED"] != Themes["dark"].Palette["RED"] {
+		t.Errorf("got RED %v, want the one of the dark theme", theme.Palette["RED"])
+	}
+	if _, ok := Themes["dark"].Palette["ACM"]; ok {
+		t.Errorf("the palette of the base theme was changed")
+	}
+
+	for _, bad := range []string{
+		`{"base": "neon"}`,
+		`{"background": "white"}`,
+		`{"palette": {"ACME": "#000"}}`,
+		`{"palette": {"Acm": "#000"}}`,
+	} {
+		if _, err := LoadTheme(strings.NewReader(bad)); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
+
+func TestLoadThemeBadSizes(t *testing.T) {
+	tests := []struct {
+		field string
+		value string
+	}{
+		{"shadowBlur", "-1"},
+		{"shadowBlur", "100000"},
+		{"strokeWidth", "0"},
+		{"strokeWidth", "-2"},
+		{"strokeWidth", "1000"},
+		{"markerSize", "0"},
+		{"markerSize", "5"},
+	}
+	for _, tt := range tests {
+		file := `{"` + tt.field + `": ` + tt.value + `}`
+		_, err := LoadTheme(strings.NewReader(file))
+		if err == nil || !strings.Contains(err.Error(), tt.field) {
+			t.Errorf("%s: got error %v, want one naming %s", file, err, tt.field)
+		}
+	}
+	if _, err := LoadTheme(strings.NewReader(`{"shadowBlur": 0, "strokeWidth": 0.5, "markerSize": 1}`)); err != nil {
+		t.Errorf("sizes at the limits: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package graphical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+/*
+WriteTikZ writes the diagram as a TikZ picture, in the same order and with
+the same colors as RenderDiagram paints it:
+
+  - every shape outline becomes a \fill and a \draw command; round corners
+    become elliptical arcs, the curves of document, storage and ellipse
+    shapes stay Bézier curves, and dashed shapes are drawn dashed,
+  - point markers become circles,
+  - labels become \node elements sized like in the image, but in the
+    document's font.
+
+The coordinates are the pixel coordinates of the image, 1pt each, and the
+outlines are shaped according to opt. If standalone is true, the picture
+is wrapped in a complete document of the standalone class; otherwise it
+can be \input into another document that loads the tikz package.
+*/
+func (d *Diagram) WriteTikZ(w io.Writer, opt Options, standalone bool) error {
+	bw := bufio.NewWriter(w)
+	if standalone {
+		fmt.Fprintln(bw, `\documentclass[tikz]{standalone}`)
+		fmt.Fprintln(bw, `\begin{document}`)
+	}
+	theme := opt.theme()
+	fmt.Fprintf(bw, "\\begin{tikzpicture}[x=1pt, y=-1pt, line width=%gpt, line join=round]\n", theme.StrokeWidth)
+	// paint the background, unless it is as white as the page
+	if theme.Background != WHITE {
+		fmt.Fprintf(bw, "\\fill[%s] (0,0) rectangle (%d,%d);\n", tikzColor("fill", theme.Background), d.Grid.W, d.Grid.H)
+	}
+
+	shapes := append([]Shape(nil), d.Shapes...)
+	storageShapes := []Shape{}
+	for _, shape := range shapes {
+		if shape.Type == TYPE_STORAGE {
+			storageShapes = append(storageShapes, shape)
+		}
+	}
+	sort.Sort(BackToFront(storageShapes))
+	sort.Sort(LargeFirst(shapes))
+	others := []Shape{}
+	for _, shape := range shapes {
+		if shape.Type != TYPE_STORAGE {
+			others = append(others, shape)
+		}
+	}
+
+	ordered := append(storageShapes, others...)
+	hops := lineHops(ordered, opt)
+	arrowheads, pointMarkers := []Shape{}, []Shape{}
+	for i, shape := range ordered {
+		switch shape.Type {
+		case TYPE_ARROWHEAD:
+			arrowheads = append(arrowheads, shape)
+			continue
+		case TYPE_POINT_MARKER:
+			pointMarkers = append(pointMarkers, shape)
+			continue
+		case TYPE_CUSTOM:
+			continue
+		}
+		if len(shape.Points) == 0 {
+			continue
+		}
+		path := shape.makeIntoOutlineWithHops(d.Grid, opt, hops, i)
+		if path == nil {
+			continue
+		}
+		outline := tikzPath(path)
+		if shape.Closed && !shape.Dashed {
+			color := theme.Background
+			if shape.FillColor != nil {
+				color = *shape.FillColor
+			}
+			fmt.Fprintf(bw, "\\fill[%s] %s;\n", tikzColor("fill", color), outline)
+		}
+		opts := tikzColor("draw", shape.StrokeColor)
+		if shape.Dashed {
+			opts += ", dashed"
+		}
+		fmt.Fprintf(bw, "\\draw[%s] %s;\n", opts, outline)
+	}
+
+	for _, shape := range arrowheads {
+		path := shape.MakeIntoOutline(d.Grid, opt)
+		if path == nil {
+			continue
+		}
+		outline := tikzPath(path)
+		filled, hollow, stroked := shape.Arrowhead.paint()
+		switch {
+		case filled && shape.FillColor != nil:
+			fmt.Fprintf(bw, "\\fill[%s] %s;\n", tikzColor("fill", *shape.FillColor), outline)
+		case filled:
+			fmt.Fprintf(bw, "\\fill[%s] %s;\n", tikzColor("fill", shape.StrokeColor), outline)
+		case hollow:
+			fmt.Fprintf(bw, "\\fill[%s] %s;\n", tikzColor("fill", theme.Background), outline)
+		}
+		if stroked {
+			fmt.Fprintf(bw, "\\draw[%s] %s;\n", tikzColor("draw", shape.StrokeColor), outline)
+		}
+	}
+
+	for _, shape := range pointMarkers {
+		if len(shape.Points) != 1 {
+			continue
+		}
+		center := shape.Points[0]
+		diameter := theme.MarkerSize * d.Grid.MinimumOfCellDimensions()
+		fmt.Fprintf(bw, "\\filldraw[%s, %s] (%g,%g) circle[radius=%g];\n",
+			tikzColor("fill", theme.Background), tikzColor("draw", shape.StrokeColor), center.X, center.Y, diameter/2)
+	}
+
+	for _, label := range d.Labels {
+		fmt.Fprintf(bw, "\\node[anchor=base west, inner sep=0, font=\\fontsize{%g}{%g}\\selectfont, %s] at (%d,%d) {%s};\n",
+			label.FontSize, math.Ceil(label.FontSize*1.2), tikzColor("text", label.Color), label.X, label.Y, tikzEscape(label.Text))
+	}
+
+	fmt.Fprintln(bw, `\end{tikzpicture}`)
+	if standalone {
+		fmt.Fprintln(bw, `\end{document}`)
+	}
+	return bw.Flush()
+}
+
+func tikzColor(key string, c Color) string {
+	s := fmt.Sprintf("%s={rgb,255:red,%d;green,%d;blue,%d}", key, c.R, c.G, c.B)
+	if c.A != 255 {
+		s += fmt.Sprintf(", %s opacity=%g", key, float64(c.A)/255)
+	}
+	return s
+}
+
+func tikzPath(path Path) string {
+	buf := []string{}
+	var last Point
+	for i, seg := range path {
+		pts := seg.Points
+		switch seg.Op {
+		case PATH_START:
+			buf = append(buf, tikzPoint(pts[0]))
+		case PATH_LINE:
+			if i == len(path)-1 && i > 1 && pts[0] == path[0].End() {
+				buf = append(buf, "-- cycle")
+			} else {
+				buf = append(buf, "-- "+tikzPoint(pts[0]))
+			}
+		case PATH_QUAD:
+			if arc, ok := tikzArc(last, pts[0], pts[1]); ok {
+				buf = append(buf, arc)
+				break
+			}
+			// elevate to a cubic curve
+			c1 := Point{X: last.X + 2*(pts[0].X-last.X)/3, Y: last.Y + 2*(pts[0].Y-last.Y)/3}
+			c2 := Point{X: pts[1].X + 2*(pts[0].X-pts[1].X)/3, Y: pts[1].Y + 2*(pts[0].Y-pts[1].Y)/3}
+			buf = append(buf, fmt.Sprintf(".. controls %s and %s .. %s", tikzPoint(c1), tikzPoint(c2), tikzPoint(pts[1])))
+		case PATH_CUBIC:
+			buf = append(buf, fmt.Sprintf(".. controls %s and %s .. %s", tikzPoint(pts[0]), tikzPoint(pts[1]), tikzPoint(pts[2])))
+		}
+		last = seg.End()
+	}
+	return strings.Join(buf, " ")
+}
+
+// tikzArc returns a quarter of an ellipse equivalent to a round corner,
+// i.e. a quadratic curve from entry to exit with the corner as its
+// control point, both ends lying on the sides of the corner.
+func tikzArc(entry, corner, exit Point) (string, bool) {
+	if entry == corner || exit == corner {
+		return "", false
+	}
+	if !(entry.X == corner.X && corner.Y == exit.Y) && !(entry.Y == corner.Y && corner.X == exit.X) {
+		return "", false
+	}
+	center := Point{X: entry.X + exit.X - corner.X, Y: entry.Y + exit.Y - corner.Y}
+	rx := math.Abs(entry.X-center.X) + math.Abs(exit.X-center.X)
+	ry := math.Abs(entry.Y-center.Y) + math.Abs(exit.Y-center.Y)
+	start := math.Atan2((entry.Y-center.Y)/ry, (entry.X-center.X)/rx) * 180 / math.Pi
+	end := math.Atan2((exit.Y-center.Y)/ry, (exit.X-center.X)/rx) * 180 / math.Pi
+	switch sweep := end - start; {
+	case sweep > 180:
+		end -= 360
+	case sweep < -180:
+		end += 360
+	}
+	return fmt.Sprintf("arc[start angle=%g, end angle=%g, x radius=%g, y radius=%g]", start, end, rx, ry), true
+}
+
+func tikzPoint(p Point) string {
+	return fmt.Sprintf("(%g,%g)", p.X, p.Y)
+}
+
+var tikzEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`$`, `\$`,
+	`&`, `\&`,
+	`#`, `\#`,
+	`%`, `\%`,
+	`_`, `\_`,
+	`^`, `\textasciicircum{}`,
+	`~`, `\textasciitilde{}`,
+	`•`, `\textbullet{}`,
+)
+
+func tikzEscape(s string) string {
+	return tikzEscaper.Replace(s)
+}
@@ -0,0 +1,70 @@
+package graphical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// tikzTestDiagram has a box with a round corner, a dashed line ending in
+// an arrowhead, a point marker and a label with characters special to
+// TeX.
+func tikzTestDiagram() *Diagram {
+	box := NewShape(Point{X: 5, Y: 7, Type: POINT_ROUND}, Point{X: 45, Y: 7},
+		Point{X: 45, Y: 35}, Point{X: 5, Y: 35})
+	box.Closed = true
+	box.FillColor = &Color{R: 255, G: 0, B: 0, A: 128}
+	line := NewShape(Point{X: 45, Y: 21}, Point{X: 90, Y: 21})
+	line.Dashed = true
+	head := NewShape(Point{X: 85, Y: 14}, Point{X: 95, Y: 21}, Point{X: 85, Y: 28})
+	head.Type = TYPE_ARROWHEAD
+	head.Closed = true
+	marker := NewShape(Point{X: 45, Y: 21})
+	marker.Type = TYPE_POINT_MARKER
+	return &Diagram{
+		Grid:   Grid{W: 100, H: 42, CellW: 10, CellH: 14},
+		Shapes: []Shape{*box, *line, *head, *marker},
+		Labels: []Label{{Text: "50% a_b", FontSize: 12, X: 15, Y: 25, Color: Color{A: 255}}},
+	}
+}
+
+func TestWriteTikZ(t *testing.T) {
+	var buf bytes.Buffer
+	err := tikzTestDiagram().WriteTikZ(&buf, Options{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `\documentclass[tikz]{standalone}
+\begin{document}
+\begin{tikzpicture}[x=1pt, y=-1pt, line width=1pt, line join=round]
+\fill[fill={rgb,255:red,255;green,0;blue,0}, fill opacity=0.5019607843137255] (5,14) arc[start angle=180, end angle=270, x radius=5, y radius=7] -- (45,7) -- (45,35) -- (5,35) -- cycle;
+\draw[draw={rgb,255:red,0;green,0;blue,0}] (5,14) arc[start angle=180, end angle=270, x radius=5, y radius=7] -- (45,7) -- (45,35) -- (5,35) -- cycle;
+\draw[draw={rgb,255:red,0;green,0;blue,0}, dashed] (45,21) -- (90,21);
+\fill[fill={rgb,255:red,0;green,0;blue,0}] (85,14) -- (95,21) -- (85,28) -- cycle;
+\filldraw[fill={rgb,255:red,255;green,255;blue,255}, draw={rgb,255:red,0;green,0;blue,0}] (45,21) circle[radius=3.5];
+\node[anchor=base west, inner sep=0, font=\fontsize{12}{15}\selectfont, text={rgb,255:red,0;green,0;blue,0}] at (15,25) {50\% a\_b};
+\end{tikzpicture}
+\end{document}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteTikZNotStandalone(t *testing.T) {
+	var standalone, embedded bytes.Buffer
+	d := tikzTestDiagram()
+	if err := d.WriteTikZ(&standalone, Options{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteTikZ(&embedded, Options{}, false); err != nil {
+		t.Fatal(err)
+	}
+	got := embedded.String()
+	if !strings.HasPrefix(got, `\begin{tikzpicture}`) || !strings.HasSuffix(got, "\\end{tikzpicture}\n") {
+		t.Errorf("not a bare picture:\n%s", got)
+	}
+	if !strings.Contains(standalone.String(), got) {
+		t.Errorf("the standalone document doesn't contain the picture:\n%s", standalone.String())
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestGridPatternMatch(t *testing.T) {
+	g := &TextGrid{Rows: [][]rune{
+		[]rune("        "),
+		[]rune(" +----+ "),
+		[]rune(" |    | "),
+		[]rune(" |    | "),
+		[]rune(" +----+ "),
+		[]rune("        "),
+	}}
+	// matching must not change the pattern, so repeat it a few times
+	for i := 0; i < 5; i++ {
+		if !normalCorner1Criteria.AnyMatch(g.TestingSubGrid(Cell{1, 1})) {
+			t.Errorf("top left corner not matched")
+		}
+	}
+	tests := []struct {
+		c     Cell
+		check func(*TextGrid, Cell) bool
+		want  bool
+	}{
+		{Cell{1, 1}, (*TextGrid).IsCorner, true},
+		{Cell{6, 1}, (*TextGrid).IsCorner, true},
+		{Cell{1, 4}, (*TextGrid).IsCorner, true},
+		{Cell{6, 4}, (*TextGrid).IsCorner, true},
+		{Cell{3, 1}, (*TextGrid).IsCorner, false},
+		{Cell{1, 1}, (*TextGrid).IsIntersection, false},
+		{Cell{1, 1}, (*TextGrid).IsBoundary, true},
+		{Cell{3, 1}, (*TextGrid).IsBoundary, true},
+		{Cell{1, 2}, (*TextGrid).IsBoundary, true},
+		{Cell{3, 2}, (*TextGrid).IsBoundary, false},
+		{Cell{3, 1}, (*TextGrid).IsHorizontalLine, true},
+		{Cell{1, 2}, (*TextGrid).IsVerticalLine, true},
+	}
+	for _, tt := range tests {
+		if got := tt.check(g, tt.c); got != tt.want {
+			t.Errorf("cell %v: got %v, want %v", tt.c, got, tt.want)
+		}
+	}
+}
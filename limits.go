@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akavel/ditaa/graphical"
+)
+
+// Limits bound the size of the diagrams interpreted by NewDiagramContext,
+// so that the work done for untrusted input stays reasonable. Zero fields
+// mean no limit.
+type Limits struct {
+	// MaxWidth and MaxHeight limit the size of the source text, in
+	// characters and lines.
+	MaxWidth, MaxHeight int
+	// MaxShapes limits the number of shapes, including arrowheads and
+	// point markers.
+	MaxShapes int
+	MaxLabels int
+}
+
+// MAX_PIXELS limits the size of the images rendered from diagrams,
+// whatever the Limits: 1<<28 pixels take 1GB in memory.
+const MAX_PIXELS = 1 << 28
+
+// LimitError is returned when a diagram exceeds one of its Limits, or
+// MAX_PIXELS.
+type LimitError struct {
+	Limit  string // "width", "height", "shape count", "label count" or "pixel count"
+	Max    int
+	Actual int // may be only as much as was found before giving up
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("diagram %s %d exceeds the limit of %d", e.Limit, e.Actual, e.Max)
+}
+
+func checkLimit(limit string, max, actual int) error {
+	if max > 0 && actual > max {
+		return &LimitError{Limit: limit, Max: max, Actual: actual}
+	}
+	return nil
+}
+
+// checkImageSize checks that an image of the size of the grid can be
+// rendered, i.e. that it has no more than MAX_PIXELS.
+func checkImageSize(g graphical.Grid) error {
+	if g.W < 0 || g.H < 0 {
+		return fmt.Errorf("invalid image size %dx%d", g.W, g.H)
+	}
+	return checkLimit("pixel count", MAX_PIXELS, g.W*g.H)
+}
+
+// CheckDiagram checks a diagram which was not made by NewDiagramContext,
+// e.g. one decoded from JSON, against the limits and MAX_PIXELS. Its
+// width and height are measured in cells, without the blank border
+// NewDiagram adds.
+func (l Limits) CheckDiagram(d *graphical.Diagram) error {
+	if d.Grid.CellW <= 0 || d.Grid.CellH <= 0 {
+		return fmt.Errorf("invalid cell size %dx%d", d.Grid.CellW, d.Grid.CellH)
+	}
+	err := checkImageSize(d.Grid)
+	if err != nil {
+		return err
+	}
+	w := d.Grid.W/d.Grid.CellW - 2*blankBorderSize
+	h := d.Grid.H/d.Grid.CellH - 2*blankBorderSize
+	for _, c := range []struct {
+		limit       string
+		max, actual int
+	}{
+		{"width", l.MaxWidth, w},
+		{"height", l.MaxHeight, h},
+		{"shape count", l.MaxShapes, len(d.Shapes)},
+		{"label count", l.MaxLabels, len(d.Labels)},
+	} {
+		if err := checkLimit(c.limit, c.max, c.actual); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interpreter holds what the steps of NewDiagramContext need to check
+// whether they should give up, and the options changing how they work.
+type interpreter struct {
+	ctx             context.Context
+	limits          Limits
+	allCornersRound bool
+	arrowheads      graphical.ArrowheadStyle
+	theme           *graphical.Theme
+}
+
+// abort is what interpret panics with to give up; NewDiagramContext
+// returns the error.
+type abort struct{ err error }
+
+// checkContext aborts the interpretation if the context is done.
+func (in *interpreter) checkContext() {
+	if err := in.ctx.Err(); err != nil {
+		panic(abort{err})
+	}
+}
+
+// checkLimit aborts the interpretation if actual exceeds max.
+func (in *interpreter) checkLimit(limit string, max, actual int) {
+	if err := checkLimit(limit, max, actual); err != nil {
+		panic(abort{err})
+	}
+}
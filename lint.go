@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/akavel/ditaa/fontmeasure"
+	"github.com/akavel/ditaa/graphical"
+)
+
+// Lint checks the diagram source read from r for style and correctness
+// problems, without rendering it. The result contains the diagnostics of
+// NewDiagram, plus the problems that don't prevent rendering but are
+// likely mistakes. tabSize is the tab width used by the author's editor;
+// tabs are reported unless it equals DEFAULT_TAB_SIZE (0 means unknown).
+func Lint(r io.Reader, tabSize int) (Diagnostics, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	diags := Diagnostics{}
+	if tabSize != DEFAULT_TAB_SIZE {
+		lintTabs(&diags, src)
+	}
+
+	grid := NewTextGrid(0, 0)
+	err = grid.LoadFrom(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	diagram, err := NewDiagram(grid)
+	if e, ok := err.(*InterpretError); ok {
+		diags.Errorf(e.Cell, "%s", e.Message)
+		return diags.Sorted(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	diags = append(diags, diagram.Diagnostics...)
+
+	workGrid := CopyTextGrid(grid)
+	workGrid.ReplaceTypeOnLine()
+	workGrid.ReplacePointMarkersOnLine()
+	workGrid.ReplaceDiagonalLines()
+	lintLineEnds(&diags, workGrid)
+	lintArrowheads(&diags, workGrid)
+	lintLabels(&diags, &diagram.G)
+
+	return diags.Sorted(), nil
+}
+
+func lintTabs(diags *Diagnostics, src []byte) {
+	for y, line := range bytes.Split(src, []byte("\n")) {
+		for x, ch := range []rune(string(line)) {
+			if ch == '\t' {
+				diags.Warnf(Cell{x + blankBorderSize, y + blankBorderSize},
+					"tab character; ditaa expands tabs to multiples of %d columns", DEFAULT_TAB_SIZE)
+				break
+			}
+		}
+	}
+}
+
+// lintLineEnds reports line ends that almost touch each other diagonally
+// (which usually means box sides that don't line up), and the remaining
+// dangling stubs.
+func lintLineEnds(diags *Diagnostics, g *TextGrid) {
+	isLinesEnd := func(c Cell) bool { return !g.IsBlank(c) && g.IsLinesEnd(c) }
+	misaligned := NewCellSet()
+	for y := range g.Rows {
+		for x := range g.Rows[y] {
+			c := Cell{x, y}
+			if !isLinesEnd(c) {
+				continue
+			}
+			for _, other := range []Cell{{x - 1, y + 1}, {x + 1, y + 1}} {
+				// ends joined through a common neighbour belong to the same shape
+				joined := !g.IsBlank(Cell{other.X, y}) || !g.IsBlank(Cell{x, other.Y})
+				if !joined && isLinesEnd(other) {
+					diags.Errorf(c, "lines do not line up with the line at %d:%d", other.Y-blankBorderSize+1, other.X-blankBorderSize+1)
+					misaligned.Add(c)
+					misaligned.Add(other)
+				}
+			}
+		}
+	}
+	for y := range g.Rows {
+		for x := range g.Rows[y] {
+			c := Cell{x, y}
+			if !g.IsBlank(c) && g.IsStub(c) && !misaligned.Contains(c) && !g.touchesDiagonalLine(c) {
+				diags.Warnf(c, "dangling line stub")
+			}
+		}
+	}
+}
+
+func lintArrowheads(diags *Diagnostics, g *TextGrid) {
+	for _, c := range g.FindArrowheads() {
+		a, _ := g.findArrowhead(c)
+		if !a.Plain {
+			continue // the other endings may be left in the open
+		}
+		tail := a.Tail
+		if !g.IsBoundary(tail) {
+			continue // already reported by NewDiagram
+		}
+		head := Cell{2*c.X - tail.X, 2*c.Y - tail.Y}
+		if g.IsBlankXY(head.X, head.Y) {
+			diags.Warnf(c, "arrowhead %q points into nothing", g.GetCell(c))
+		}
+	}
+}
+
+func lintLabels(diags *Diagnostics, d *graphical.Diagram) {
+	font := fontmeasure.GetFontForHeight(baseFont, d.Grid.CellH)
+	for _, label := range d.Labels {
+		if label.FontSize >= font.Size {
+			continue
+		}
+		c := d.Grid.CellFor(graphical.Point{X: float64(label.X), Y: float64(label.Y - 1)})
+		diags.Warnf(Cell(c), "text %q is too wide for its cells and is drawn with a smaller font", label.Text)
+	}
+}
+
+type lintProblem struct {
+	File string `json:"file"`
+	Diagnostic
+}
+
+// lintMain runs the "lint" subcommand, printing the problems to w, and
+// returns the exit code: 0 if no errors were found, 1 if there were
+// errors, 2 if linting failed.
+func lintMain(args []string, w io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the problems as a JSON array")
+	strict := flags.Bool("strict", false, "treat warnings as errors")
+	tabSize := flags.Int("tabsize", 0, fmt.Sprintf("tab size of your editor; tabs are not reported if it is %d", DEFAULT_TAB_SIZE))
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: %s lint [FLAGS] FILE...\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	failed := false
+	problems := []lintProblem{}
+	for _, path := range flags.Args() {
+		r, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 2
+		}
+		diags, err := Lint(r, *tabSize)
+		r.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", path, err)
+			return 2
+		}
+		for _, diag := range diags {
+			if *strict {
+				diag.Severity = SEVERITY_ERROR
+			}
+			if diag.Severity == SEVERITY_ERROR {
+				failed = true
+			}
+			problems = append(problems, lintProblem{path, diag})
+		}
+	}
+
+	if *asJSON {
+		buf, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 2
+		}
+		fmt.Fprintf(w, "%s\n", buf)
+	} else {
+		for _, p := range problems {
+			fmt.Fprintf(w, "%s:%s\n", p.File, p.Diagnostic)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
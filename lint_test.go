@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		text    string
+		tabSize int
+		want    []string
+	}{
+		{"+--+\n|  |\n+--+", 0, []string{}},
+		// tabs
+		{"+-------+\n|\t|\n+-------+", 0, []string{
+			"2:2: warning: tab character; ditaa expands tabs to multiples of 8 columns"}},
+		{"+-------+\n|\t|\n+-------+", DEFAULT_TAB_SIZE, []string{}},
+		// line ends
+		{"+---+\n|   |\n +--+", 0, []string{
+			"2:1: warning: line is not connected to anything at this end",
+			"2:1: error: lines do not line up with the line at 3:2"}},
+		{"+\n+", 0, []string{
+			"1:1: warning: dangling line stub",
+			"2:1: warning: dangling line stub"}},
+		// arrowheads
+		{"+--+\n|  |\n+--+\n\n--->", 0, []string{
+			"5:1: warning: line is not connected to anything at this end",
+			"5:4: warning: arrowhead '>' points into nothing"}},
+		{"+--+   +--+\n|  |-->|  |\n+--+   +--+", 0, []string{}},
+		// labels
+		{"+-----+\n|WWWWW|\n+-----+", 0, []string{
+			"2:2: warning: text \"WWWWW\" is too wide for its cells and is drawn with a smaller font"}},
+		{"+-----+\n|iiiii|\n+-----+", 0, []string{}},
+	}
+	for _, test := range tests {
+		diags, err := Lint(strings.NewReader(test.text), test.tabSize)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		got := []string{}
+		for _, d := range diags {
+			got = append(got, d.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestLintMain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ditaa-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"clean.txt":   "+--+\n|  |\n+--+",
+		"warning.txt": "+\n+",
+		"error.txt":   "+---+\n|   |\n +--+",
+	}
+	for name, text := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{path("clean.txt")}, 0},
+		{[]string{path("warning.txt")}, 0},
+		{[]string{"-strict", path("warning.txt")}, 1},
+		{[]string{path("clean.txt"), path("error.txt")}, 1},
+		{[]string{path("missing.txt")}, 2},
+		{[]string{}, 2},
+	}
+	for _, test := range tests {
+		if got := lintMain(test.args, ioutil.Discard); got != test.want {
+			t.Errorf("lint %q: got exit code %d, want %d", test.args, got, test.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if code := lintMain([]string{path("warning.txt")}, &buf); code != 0 {
+		t.Errorf("lint: got exit code %d, want 0", code)
+	}
+	want := path("warning.txt") + ":1:1: warning: dangling line stub\n" +
+		path("warning.txt") + ":2:1: warning: dangling line stub\n"
+	if buf.String() != want {
+		t.Errorf("lint: got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if code := lintMain([]string{"-json", path("clean.txt"), path("error.txt")}, &buf); code != 1 {
+		t.Errorf("lint -json: got exit code %d, want 1", code)
+	}
+	var problems []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &problems); err != nil {
+		t.Fatalf("lint -json: %v in %q", err, buf.String())
+	}
+	wantJSON := []map[string]interface{}{
+		{"file": path("error.txt"), "line": 2.0, "col": 1.0, "severity": "warning",
+			"message": "line is not connected to anything at this end"},
+		{"file": path("error.txt"), "line": 2.0, "col": 1.0, "severity": "error",
+			"message": "lines do not line up with the line at 3:2"},
+	}
+	if !reflect.DeepEqual(problems, wantJSON) {
+		t.Errorf("lint -json: got %v, want %v", problems, wantJSON)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akavel/ditaa/graphical"
+)
+
+// mermaidShapes maps the types of nodes to the opening and closing
+// brackets of the closest Mermaid flowchart node shapes.
+var mermaidShapes = map[graphical.ShapeType][2]string{
+	graphical.TYPE_SIMPLE:           {"[", "]"},
+	graphical.TYPE_DOCUMENT:         {"[", "]"}, // no document shape in the classic syntax
+	graphical.TYPE_STORAGE:          {"[(", ")]"},
+	graphical.TYPE_IO:               {"[/", "/]"},
+	graphical.TYPE_DECISION:         {"{", "}"},
+	graphical.TYPE_MANUAL_OPERATION: {`[\`, "/]"},
+	graphical.TYPE_TRAPEZOID:        {"[/", `\]`},
+	graphical.TYPE_ELLIPSE:          {"((", "))"},
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart definition. Fill
+// colors and dashed outlines of the nodes are kept as style lines.
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "flowchart TD")
+	for _, n := range g.Nodes {
+		brackets, ok := mermaidShapes[n.Shape.Type]
+		if !ok {
+			brackets = mermaidShapes[graphical.TYPE_SIMPLE]
+		}
+		fmt.Fprintf(bw, "    %s%s%s%s\n", n.ID, brackets[0], mermaidQuote(n.Name), brackets[1])
+	}
+	for _, e := range g.Edges {
+		var arrow string
+		switch {
+		case e.Directed && e.Dashed:
+			arrow = "-.->"
+		case e.Directed:
+			arrow = "-->"
+		case e.Dashed:
+			arrow = "-.-"
+		default:
+			arrow = "---"
+		}
+		fmt.Fprintf(bw, "    %s %s %s\n", e.From, arrow, e.To)
+	}
+	for _, n := range g.Nodes {
+		styles := []string{}
+		if n.FillColor != nil {
+			styles = append(styles, "fill:"+n.FillColor.Hex())
+			if IsDark(*n.FillColor) {
+				styles = append(styles, "color:"+graphical.WHITE.Hex())
+			}
+		}
+		if n.Dashed {
+			styles = append(styles, "stroke-dasharray:5 5")
+		}
+		if len(styles) > 0 {
+			fmt.Fprintf(bw, "    style %s %s\n", n.ID, strings.Join(styles, ","))
+		}
+	}
+	return bw.Flush()
+}
+
+func mermaidQuote(s string) string {
+	if s == "" {
+		s = " "
+	}
+	return `"` + strings.Replace(s, `"`, "#quot;", -1) + `"`
+}
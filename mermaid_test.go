@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteMermaid(t *testing.T) {
+	var buf bytes.Buffer
+	err := ExtractGraph(exportTestDiagram(t)).WriteMermaid(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `flowchart TD
+    n1[("#quot;db#quot;")]
+    n2["B"]
+    n3["doc"]
+    n1 --> n2
+    n1 --- n3
+    n2 -.-> n3
+    style n2 fill:#5555bb,color:#ffffff
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"bufio"
-	"encoding/xml"
 	"fmt"
-	"github.com/akavel/ditaa/graphical"
 	"image"
 	"image/png"
 	"os"
+
+	"code.google.com/p/jamslam-freetype-go/freetype"
+
+	"github.com/akavel/ditaa/embd"
+	"github.com/akavel/ditaa/graphical"
 )
 
 const (
@@ -22,8 +25,7 @@ func LoadDiagram(path string) (*graphical.Diagram, error) {
 	}
 	defer r.Close()
 
-	diagram := graphical.Diagram{}
-	err = xml.NewDecoder(bufio.NewReader(r)).Decode(&diagram)
+	diagram, err := graphical.ReadXML(bufio.NewReader(r))
 	if err != nil {
 		return nil, fmt.Errorf("decoding diagram from '%s': %s", path, err)
 	}
@@ -31,7 +33,7 @@ func LoadDiagram(path string) (*graphical.Diagram, error) {
 	//if len(diagram.Labels)>0 {
 	//	panic(fmt.Sprintf("%s: %#v", path, diagram.Labels))
 	//}
-	return &diagram, nil
+	return diagram, nil
 }
 
 func RunRender(src, dst string) error {
@@ -39,8 +41,12 @@ func RunRender(src, dst string) error {
 	if err != nil {
 		return err
 	}
+	font, err := freetype.ParseFont(embd.File_font_ttf)
+	if err != nil {
+		return err
+	}
 	img := image.NewRGBA(image.Rect(0, 0, diagram.Grid.W, diagram.Grid.H))
-	err = graphical.RenderDiagram(img, diagram, graphical.Options{DropShadows: true})
+	err = graphical.RenderDiagram(img, diagram, graphical.Options{DropShadows: true}, font)
 	if err != nil {
 		return err
 	}
@@ -25,7 +25,7 @@ func (e edge) String() string {
 	return fmt.Sprintf("(%v, %v) -> (%v, %v)", e.start.X, e.start.Y, e.end.X, e.end.Y)
 }
 
-func separateCommonEdges(gg graphical.Grid, shapes []graphical.Shape) []graphical.Shape {
+func separateCommonEdges(in *interpreter, gg graphical.Grid, shapes []graphical.Shape) []graphical.Shape {
 	offset := gg.MinimumOfCellDimensions() / 5
 	edges := []edge{}
 
@@ -59,6 +59,7 @@ func separateCommonEdges(gg graphical.Grid, shapes []graphical.Shape) []graphica
 	startIndex := 1 //skip some to avoid duplicate comparisons and self-to-self comparisons
 
 	for _, edge1 := range edges {
+		in.checkContext()
 		for _, edge2 := range edges[startIndex:] {
 			if edge1.TouchesWith(edge2) {
 				pairs = append(pairs, [2]edge{edge1, edge2})
@@ -99,6 +100,8 @@ func (e1 edge) TouchesWith(e2 edge) bool {
 		return false
 	case e1.Vertical() && e2.Horizontal():
 		return false
+	case e1.Type() == edgeSloped || e2.Type() == edgeSloped:
+		return false
 
 	case e1.DistanceFromOrigin() != e2.DistanceFromOrigin():
 		return false
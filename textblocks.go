@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// TextAlign is the way the lines of a text block are aligned to each
+// other. The cells the text is written in are kept at the aligned edge,
+// or around the aligned center; since the font is proportional, the other
+// edges move.
+type TextAlign int
+
+const (
+	ALIGN_AUTO TextAlign = iota // guessed from the layout of the text
+	ALIGN_LEFT
+	ALIGN_CENTER
+	ALIGN_RIGHT
+)
+
+// alignTags maps the markup tags setting the alignment of the text to the
+// alignments. A tag followed by text on its line aligns the block of that
+// text; a tag on its own aligns all the text in the shape containing it.
+var alignTags = map[string]TextAlign{
+	"left":   ALIGN_LEFT,
+	"center": ALIGN_CENTER,
+	"right":  ALIGN_RIGHT,
+}
+
+// textBlock is a run of the strings of a text group on consecutive rows,
+// one on each row, which are laid out together, like the lines of a
+// paragraph.
+type textBlock struct {
+	Lines []CellStringPair
+}
+
+// findTextBlocks groups the strings of a text group, in the order they are
+// returned by FindStrings, into blocks. A row with more than one string,
+// e.g. a table row, breaks the blocks, and each of its strings makes a
+// block of its own.
+func findTextBlocks(strs []CellStringPair) []textBlock {
+	perRow := map[int]int{}
+	for _, s := range strs {
+		perRow[s.C.Y]++
+	}
+	blocks := []textBlock{}
+	for _, s := range strs {
+		if n := len(blocks); n > 0 && perRow[s.C.Y] == 1 {
+			last := blocks[n-1].Lines[len(blocks[n-1].Lines)-1]
+			if last.C.Y == s.C.Y-1 && perRow[last.C.Y] == 1 &&
+				s.C.X <= last.end()+1 && last.C.X <= s.end()+1 {
+				blocks[n-1].Lines = append(blocks[n-1].Lines, s)
+				continue
+			}
+		}
+		blocks = append(blocks, textBlock{Lines: []CellStringPair{s}})
+	}
+	return blocks
+}
+
+// end returns the column of the last character of the string.
+func (p CellStringPair) end() int {
+	return p.C.X + utf8.RuneCountInString(p.S) - 1
+}
+
+// isBulleted tells if the string starts with a bullet (see
+// TextGrid.replaceBullets).
+func (p CellStringPair) isBulleted() bool {
+	return strings.HasPrefix(p.S, "•")
+}
+
+// guessAlign decides how the lines of a block without an alignment tag
+// are aligned: to the left if they have bullets or start in the same
+// column, to the right if they end in the same column, and centered if
+// they have the same center, or the same center give or take a cell and
+// each starts more than a cell away from the one before, so that left
+// aligned text with a short line in the middle isn't taken for centered.
+// Ragged text is aligned to the left, which keeps its indentation. Single
+// lines are left to the caller (ALIGN_AUTO), except for the bulleted ones.
+func (b textBlock) guessAlign() TextAlign {
+	first := b.Lines[0]
+	if len(b.Lines) == 1 {
+		if first.isBulleted() {
+			return ALIGN_LEFT
+		}
+		return ALIGN_AUTO
+	}
+	sameStart, sameEnd, sameCenter, nearCenter, shifted := true, true, true, true, true
+	for i, l := range b.Lines {
+		if l.isBulleted() {
+			return ALIGN_LEFT
+		}
+		sameStart = sameStart && l.C.X == first.C.X
+		sameEnd = sameEnd && l.end() == first.end()
+		// twice the centers, to compare them in whole cells
+		centers := abs(l.C.X + l.end() - first.C.X - first.end())
+		sameCenter = sameCenter && centers == 0
+		nearCenter = nearCenter && centers <= 2
+		shifted = shifted && (i == 0 || abs(l.C.X-b.Lines[i-1].C.X) > 1)
+	}
+	switch {
+	case sameCenter:
+		return ALIGN_CENTER
+	case sameStart:
+		return ALIGN_LEFT
+	case sameEnd:
+		return ALIGN_RIGHT
+	case nearCenter && shifted:
+		return ALIGN_CENTER
+	}
+	return ALIGN_LEFT
+}
+
+// span returns the first and last columns of the block.
+func (b textBlock) span() (min, max int) {
+	min, max = b.Lines[0].C.X, b.Lines[0].end()
+	for _, l := range b.Lines[1:] {
+		if l.C.X < min {
+			min = l.C.X
+		}
+		if l.end() > max {
+			max = l.end()
+		}
+	}
+	return min, max
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestGuessAlign(t *testing.T) {
+	line := func(x, y int, s string) CellStringPair { return CellStringPair{C: Cell{x, y}, S: s} }
+	tests := []struct {
+		name  string
+		lines []CellStringPair
+		want  TextAlign
+	}{
+		{"single line", []CellStringPair{line(3, 1, "text")}, ALIGN_AUTO},
+		{"single bullet", []CellStringPair{line(3, 1, "• item")}, ALIGN_LEFT},
+		{"same start", []CellStringPair{line(3, 1, "abc"), line(3, 2, "a")}, ALIGN_LEFT},
+		{"same end", []CellStringPair{line(3, 1, "abc"), line(5, 2, "a")}, ALIGN_RIGHT},
+		{"same start and end", []CellStringPair{line(3, 1, "abc"), line(3, 2, "def")}, ALIGN_CENTER},
+		{"same center", []CellStringPair{line(5, 1, "Centered"), line(2, 2, "lines of text"), line(6, 3, "in a box")}, ALIGN_CENTER},
+		{"bullets", []CellStringPair{line(5, 1, "• ab"), line(4, 2, "• abcd")}, ALIGN_LEFT},
+		{"ragged", []CellStringPair{line(3, 1, "abc"), line(7, 2, "a"), line(1, 3, "ab")}, ALIGN_LEFT},
+		// bug17.txt: a short line in the middle of left aligned ones
+		{"left with a centered line", []CellStringPair{line(33, 18, "World"), line(33, 19, "Server"), line(35, 20, "1")}, ALIGN_LEFT},
+		{"same center, a cell apart", []CellStringPair{line(3, 1, "abcd"), line(4, 2, "ab")}, ALIGN_CENTER},
+		{"near center, a cell apart", []CellStringPair{line(3, 1, "abcde"), line(4, 2, "ab")}, ALIGN_LEFT},
+	}
+	for _, tt := range tests {
+		if got := (textBlock{Lines: tt.lines}).guessAlign(); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -14,23 +14,23 @@ import (
 
 const blankBorderSize = 2
 
-var humanColorCodes = map[string]string{
-	"GRE": "9D9",
-	"BLU": "55B",
-	"PNK": "FAA",
-	"RED": "E32",
-	"YEL": "FF3",
-	"BLK": "000",
+// markupTags maps the extended markup tags to the types of the shapes
+// containing them.
+var markupTags = map[string]graphical.ShapeType{
+	"d":  graphical.TYPE_DOCUMENT,
+	"s":  graphical.TYPE_STORAGE,
+	"io": graphical.TYPE_IO,
+	"c":  graphical.TYPE_DECISION,
+	"mo": graphical.TYPE_MANUAL_OPERATION,
+	"tr": graphical.TYPE_TRAPEZOID,
+	"o":  graphical.TYPE_ELLIPSE,
 }
 
-var markupTags = map[string]struct{}{
-	"d":  struct{}{},
-	"s":  struct{}{},
-	"io": struct{}{},
-	"c":  struct{}{},
-	"mo": struct{}{},
-	"tr": struct{}{},
-	"o":  struct{}{},
+// cornerTags maps the markup tags setting the corners of the shapes
+// containing them to the type of their corner points.
+var cornerTags = map[string]graphical.PointType{
+	"round": graphical.POINT_ROUND,
+	"sharp": graphical.POINT_NORMAL,
 }
 
 var _SPACE = []byte{' '}
@@ -122,7 +122,6 @@ func (t *TextGrid) LoadFrom(r io.Reader) error {
 	t.Rows = newrows
 
 	t.replaceBullets()
-	t.replaceHumanColorCodes()
 
 	return nil
 }
@@ -168,24 +167,24 @@ func (t *TextGrid) replaceBullets() {
 	}
 }
 
-func (t *TextGrid) replaceHumanColorCodes() {
-	for y, row := range t.Rows {
-		s := string(row)
-		for k, v := range humanColorCodes {
-			k, v = "c"+k, "c"+v
-			s = strings.Replace(s, k, v, -1)
-		}
-		t.Rows[y] = []rune(s)
+// Set changes the character at x, y; it does nothing outside the grid.
+func (t *TextGrid) Set(x, y int, ch rune) {
+	if t.isInside(x, y) {
+		t.Rows[y][x] = ch
 	}
 }
 
-func (t *TextGrid) Set(x, y int, ch rune) { t.Rows[y][x] = ch }
+// Get returns the character at x, y, or 0 outside the grid.
 func (t *TextGrid) Get(x, y int) rune {
-	if x >= t.Width() || y >= t.Height() || x < 0 || y < 0 {
+	if !t.isInside(x, y) {
 		return 0
 	}
 	return t.Rows[y][x]
 }
+
+func (t *TextGrid) isInside(x, y int) bool {
+	return y >= 0 && y < len(t.Rows) && x >= 0 && x < len(t.Rows[y])
+}
 func (t *TextGrid) SetCell(c Cell, ch rune) { t.Set(c.X, c.Y, ch) }
 func (t *TextGrid) GetCell(c Cell) rune     { return t.Get(c.X, c.Y) }
 
@@ -200,7 +199,21 @@ func (t *TextGrid) Width() int {
 func (t *TextGrid) TestingSubGrid(c Cell) *TextGrid {
 	return t.SubGrid(c.X-1, c.Y-1, 3, 3)
 }
+
+// SubGrid returns the w×h area of the grid starting at x, y. The parts of
+// the area outside the grid are blank.
 func (t *TextGrid) SubGrid(x, y, w, h int) *TextGrid {
+	if !t.isInside(x, y) || !t.isInside(x+w-1, y+h-1) {
+		g := NewTextGrid(w, h)
+		for yi := 0; yi < h; yi++ {
+			for xi := 0; xi < w; xi++ {
+				if ch := t.Get(x+xi, y+yi); ch != 0 {
+					g.Set(xi, yi, ch)
+				}
+			}
+		}
+		return g
+	}
 	g := NewTextGrid(0, 0)
 	for i := 0; i < h; i++ {
 		g.Rows = append(g.Rows, t.Rows[y+i][x:x+w])
@@ -318,8 +331,9 @@ func (t *TextGrid) fillContinuousArea(x, y int, ch rune) *CellSet {
 	return t.seedFillOld(Cell{x, y}, ch)
 }
 
-// Makes blank all the cells that contain non-text elements.
-func (t *TextGrid) RemoveNonText() {
+// Makes blank all the cells that contain non-text elements. The palette
+// has the named color codes, as in FindColorCodes.
+func (t *TextGrid) RemoveNonText(palette map[string]graphical.Color) {
 	w, h := t.Width(), t.Height()
 
 	//the following order is significant
@@ -337,15 +351,12 @@ func (t *TextGrid) RemoveNonText() {
 	}
 
 	// remove color codes
-	for _, pair := range t.FindColorCodes() {
+	for _, pair := range t.FindColorCodes(palette) {
 		c := pair.Cell
-		t.SetCell(c, ' ')
-		c = c.East()
-		t.SetCell(c, ' ')
-		c = c.East()
-		t.SetCell(c, ' ')
-		c = c.East()
-		t.SetCell(c, ' ')
+		for range pair.Code {
+			t.SetCell(c, ' ')
+			c = c.East()
+		}
 	}
 
 	// remove boundaries
@@ -353,7 +364,7 @@ func (t *TextGrid) RemoveNonText() {
 	for yi := 0; yi < h; yi++ {
 		for xi := 0; xi < w; xi++ {
 			c := Cell{xi, yi}
-			if t.IsBoundary(c) {
+			if t.IsBoundary(c) || t.IsDiagonalLine(c) {
 				rm = append(rm, c)
 			}
 		}
@@ -395,6 +406,21 @@ type CellTagPair struct {
 }
 
 func (t *TextGrid) findMarkupTags() []CellTagPair {
+	result := []CellTagPair{}
+	for _, pair := range t.findAllMarkupTags() {
+		_, isType := markupTags[pair.Tag]
+		_, isCorners := cornerTags[pair.Tag]
+		_, isAlign := alignTags[pair.Tag]
+		if isType || isCorners || isAlign {
+			result = append(result, pair)
+		}
+	}
+	return result
+}
+
+// findAllMarkupTags returns everything that looks like a markup tag,
+// including the tags that are not known to ditaa.
+func (t *TextGrid) findAllMarkupTags() []CellTagPair {
 	result := []CellTagPair{}
 	w, h := t.Width(), t.Height()
 	for y := 0; y < h; y++ {
@@ -409,11 +435,7 @@ func (t *TextGrid) findMarkupTags() []CellTagPair {
 			if len(m) == 0 {
 				continue
 			}
-			tagName := m[1]
-			if _, ok := markupTags[tagName]; !ok {
-				continue
-			}
-			result = append(result, CellTagPair{cell, tagName})
+			result = append(result, CellTagPair{cell, m[1]})
 		}
 	}
 	return result
@@ -424,10 +446,12 @@ type Color uint32
 type CellColorPair struct {
 	Cell
 	graphical.Color
+	Code string // as written
 }
 
 var (
-	colorCodePattern = regexp.MustCompile(`c[A-F0-9]{3}`)
+	colorCodePattern    = regexp.MustCompile(`c[A-F0-9]{3}`)
+	hexColorCodePattern = regexp.MustCompile(`^c#[0-9A-Fa-f]{6}(?:[0-9A-Fa-f]{2})?`)
 )
 
 func unhex(c byte) uint8 {
@@ -437,25 +461,47 @@ func unhex(c byte) uint8 {
 	return 10 + c - 'A'
 }
 
-func (t *TextGrid) FindColorCodes() []CellColorPair {
+/*
+FindColorCodes finds the color codes, which are 'c' followed by:
+
+  - the name of a color of the palette, e.g. cRED,
+  - three hex digits, e.g. cE32,
+  - '#' and six hex digits, e.g. c#1E90FF, or eight with the alpha,
+    e.g. c#1E90FF80.
+
+A name of the palette made of hex digits hides the hex code.
+*/
+func (t *TextGrid) FindColorCodes(palette map[string]graphical.Color) []CellColorPair {
 	result := []CellColorPair{}
 	w, h := t.Width(), t.Height()
 	for yi := 0; yi < h; yi++ {
 		for xi := 0; xi < w-3; xi++ {
 			c := Cell{xi, yi}
 			s := t.GetStringAt(c, 4)
-			if colorCodePattern.MatchString(s) {
+			end := xi + 10
+			if end > w {
+				end = w
+			}
+			long := t.GetStringAt(c, end-xi)
+			pair := CellColorPair{Cell: c, Code: s}
+			if color, ok := palette[s[1:]]; ok && s[0] == 'c' {
+				pair.Color = color
+			} else if colorCodePattern.MatchString(s) {
 				cR, cG, cB := s[1], s[2], s[3]
-				result = append(result, CellColorPair{
-					Cell: c,
-					Color: graphical.Color{
-						R: unhex(cR) * 17,
-						G: unhex(cG) * 17,
-						B: unhex(cB) * 17,
-						A: 255,
-					},
-				})
+				pair.Color = graphical.Color{
+					R: unhex(cR) * 17,
+					G: unhex(cG) * 17,
+					B: unhex(cB) * 17,
+					A: 255,
+				}
+			} else if code := hexColorCodePattern.FindString(long); code != "" {
+				pair.Color, _ = graphical.ParseColor(code[1:])
+				pair.Code = code
+			} else {
+				continue
 			}
+			result = append(result, pair)
+			xi += len(pair.Code) - 1
 		}
 	}
 	return result
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testTexts = "orig-java/tests/text"
+
+func loadTestGrid(t testing.TB, name string) *TextGrid {
+	f, err := os.Open(filepath.Join(testTexts, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	grid := NewTextGrid(0, 0)
+	err = grid.LoadFrom(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grid
+}
+
+func addSquareToCellSet(set *CellSet, x, y, w, h int) {
+	for xx := 0; xx < w; xx++ {
+		for yy := 0; yy < h; yy++ {
+			set.Add(Cell{x + xx, y + yy})
+		}
+	}
+}
+
+// cellSetFromCellsString parses the format of CellSet.GetCellsAsString.
+func cellSetFromCellsString(t *testing.T, s string) *CellSet {
+	set := NewCellSet()
+	for _, cs := range strings.Split(s, "/") {
+		xy := strings.Split(strings.Trim(cs, "()"), ",")
+		if len(xy) != 2 {
+			t.Fatalf("bad cell %q", cs)
+		}
+		x, err := strconv.Atoi(strings.TrimSpace(xy[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, err := strconv.Atoi(strings.TrimSpace(xy[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.Add(Cell{x, y})
+	}
+	return set
+}
+
+func checkCellSet(t *testing.T, got, want *CellSet) {
+	t.Helper()
+	if !got.Equals(want) {
+		t.Errorf("got cells:\n%s\nwant:\n%s", got.GetCellsAsString(), want.GetCellsAsString())
+	}
+}
+
+func TestFillContinuousArea(t *testing.T) {
+	type square struct{ x, y, w, h int }
+	tests := []struct {
+		file    string
+		x, y    int
+		size    int
+		squares []square
+		extra   []Cell
+	}{
+		{"simple_square01.txt", 0, 0, 64, []square{{0, 0, 11, 2}, {0, 7, 11, 2}, {0, 2, 2, 5}, {9, 2, 2, 5}}, nil},
+		{"simple_square01.txt", 3, 3, 15, []square{{3, 3, 5, 3}}, nil},
+		{"simple_U01.txt", 3, 3, 62, []square{{3, 3, 5, 5}, {14, 3, 5, 5}, {8, 6, 6, 2}}, nil},
+		{"simple_U01.txt", 0, 0, 128, []square{{0, 0, 2, 11}, {20, 0, 2, 11}, {0, 0, 22, 2}, {0, 9, 22, 2}, {9, 2, 4, 3}}, nil},
+		{"simple_S01.txt", 0, 0, 246, []square{{0, 0, 25, 2}, {0, 12, 25, 2}, {0, 0, 2, 14}, {23, 0, 2, 14}, {9, 0, 7, 7}, {0, 7, 9, 7}, {16, 7, 9, 7}}, []Cell{{22, 6}}},
+		{"simple_S01.txt", 3, 3, 15, []square{{3, 3, 5, 3}}, nil},
+		{"simple_S01.txt", 17, 3, 15, []square{{17, 3, 5, 3}}, nil},
+	}
+	for _, tt := range tests {
+		grid := loadTestGrid(t, tt.file)
+		filled := grid.fillContinuousArea(tt.x, tt.y, '*')
+		if len(filled.Set) != tt.size {
+			t.Errorf("%s: filling from (%d, %d): got %d cells, want %d", tt.file, tt.x, tt.y, len(filled.Set), tt.size)
+		}
+		want := NewCellSet()
+		for _, sq := range tt.squares {
+			addSquareToCellSet(want, sq.x, sq.y, sq.w, sq.h)
+		}
+		for _, c := range tt.extra {
+			want.Add(c)
+		}
+		checkCellSet(t, filled, want)
+	}
+}
+
+func abstractCopy(grid *TextGrid) *TextGrid {
+	whole := NewCellSet()
+	addSquareToCellSet(whole, 0, 0, grid.Width(), grid.Height())
+	return &TextGrid{Rows: NewAbstractionGrid(grid, whole).Rows}
+}
+
+func TestFindBoundariesExpandingFromSquare(t *testing.T) {
+	grid := abstractCopy(loadTestGrid(t, "simple_square01.txt"))
+	boundaries := findBoundariesExpandingFrom(grid, Cell{8, 8})
+	if len(boundaries.Set) != 56 {
+		t.Errorf("got %d boundary cells, want 56", len(boundaries.Set))
+	}
+	want := NewCellSet()
+	addSquareToCellSet(want, 8, 7, 17, 1)
+	addSquareToCellSet(want, 8, 19, 17, 1)
+	addSquareToCellSet(want, 7, 8, 1, 11)
+	addSquareToCellSet(want, 25, 8, 1, 11)
+	checkCellSet(t, boundaries, want)
+}
+
+func TestFindBoundariesExpandingFromUInside(t *testing.T) {
+	grid := abstractCopy(loadTestGrid(t, "simple_U01.txt"))
+	boundaries := findBoundariesExpandingFrom(grid, Cell{8, 8})
+	if len(boundaries.Set) != 150 {
+		t.Errorf("got %d boundary cells, want 150", len(boundaries.Set))
+	}
+	checkCellSet(t, boundaries, cellSetFromCellsString(t, "(47,25)/(43,7)/(25,25)/(58,18)/(18,7)/(52,7)/(58,12)/(13,25)/(7,8)/"+
+		"(7,11)/(57,7)/(7,10)/(24,25)/(18,25)/(12,7)/(37,25)/(58,19)/(35,16)/(58,11)/(25,12)/"+
+		"(54,25)/(29,16)/(16,25)/(49,7)/(7,12)/(26,25)/(19,7)/(58,17)/(55,25)/(46,25)/(17,25)/"+
+		"(58,13)/(32,16)/(25,11)/(51,25)/(21,25)/(58,14)/(36,16)/(7,16)/(32,25)/(55,7)/(25,8)/"+
+		"(10,25)/(58,21)/(20,7)/(27,25)/(31,16)/(58,9)/(45,25)/(58,20)/(56,25)/(10,7)/(39,25)/"+
+		"(44,7)/(58,10)/(33,16)/(46,7)/(7,9)/(58,22)/(17,7)/(48,25)/(7,15)/(38,16)/(54,7)/(11,25)/"+
+		"(9,7)/(7,14)/(58,24)/(40,25)/(30,16)/(58,23)/(47,7)/(7,13)/(19,25)/(8,7)/(25,16)/(53,25)/"+
+		"(39,16)/(23,7)/(42,25)/(53,7)/(40,15)/(7,23)/(12,25)/(48,7)/(30,25)/(42,7)/(7,24)/(40,14)/"+
+		"(14,7)/(35,25)/(52,25)/(58,16)/(25,15)/(9,25)/(40,16)/(7,22)/(43,25)/(25,9)/(29,25)/"+
+		"(56,7)/(28,16)/(22,7)/(8,25)/(25,10)/(15,7)/(41,7)/(34,25)/(11,7)/(45,7)/(7,21)/(7,18)/"+
+		"(38,25)/(50,7)/(58,15)/(15,25)/(40,12)/(27,16)/(21,7)/(57,25)/(44,25)/(25,13)/(37,16)/"+
+		"(16,7)/(7,17)/(25,14)/(50,25)/(20,25)/(33,25)/(40,13)/(22,25)/(26,16)/(24,7)/(31,25)/"+
+		"(40,8)/(7,19)/(58,8)/(41,25)/(28,25)/(40,11)/(14,25)/(34,16)/(51,7)/(7,20)/(40,10)/"+
+		"(23,25)/(13,7)/(49,25)/(40,9)/(36,25)"))
+}
+
+func TestFindBoundariesExpandingFromUOutside(t *testing.T) {
+	grid := abstractCopy(loadTestGrid(t, "simple_U01.txt"))
+	boundaries := findBoundariesExpandingFrom(grid, Cell{0, 0})
+	if len(boundaries.Set) != 154 {
+		t.Errorf("got %d boundary cells, want 154", len(boundaries.Set))
+	}
+	checkCellSet(t, boundaries, cellSetFromCellsString(t, "(47,25)/(43,7)/(25,25)/(58,18)/(18,7)/(52,7)/(13,25)/(58,12)/(7,8)/(7,11)/"+
+		"(7,10)/(57,7)/(24,25)/(18,25)/(12,7)/(37,25)/(58,19)/(35,16)/(58,11)/"+
+		"(25,12)/(54,25)/(29,16)/(16,25)/(7,7)/(7,12)/(49,7)/(26,25)/(19,7)/(58,17)/"+
+		"(55,25)/(46,25)/(17,25)/(58,13)/(32,16)/(25,11)/(51,25)/(21,25)/(36,16)/"+
+		"(58,14)/(7,16)/(32,25)/(25,8)/(10,25)/(55,7)/(58,21)/(20,7)/(27,25)/(31,16)/"+
+		"(58,9)/(45,25)/(58,20)/(25,7)/(56,25)/(10,7)/(39,25)/(44,7)/(33,16)/(58,10)/"+
+		"(7,9)/(46,7)/(58,22)/(17,7)/(48,25)/(7,15)/(38,16)/(54,7)/(11,25)/(9,7)/(7,14)/"+
+		"(58,24)/(40,25)/(30,16)/(58,23)/(7,13)/(47,7)/(19,25)/(8,7)/(53,25)/(39,16)/(23,7)/"+
+		"(42,25)/(40,15)/(7,23)/(12,25)/(53,7)/(48,7)/(30,25)/(7,24)/(7,25)/(42,7)/(40,14)/"+
+		"(14,7)/(52,25)/(35,25)/(58,16)/(25,15)/(9,25)/(7,22)/(43,25)/(25,9)/(29,25)/(28,16)/"+
+		"(56,7)/(22,7)/(25,10)/(8,25)/(15,7)/(41,7)/(34,25)/(11,7)/(7,21)/(45,7)/(7,18)/"+
+		"(40,7)/(38,25)/(50,7)/(15,25)/(58,15)/(40,12)/(27,16)/(21,7)/(57,25)/(44,25)/"+
+		"(25,13)/(37,16)/(16,7)/(25,14)/(7,17)/(50,25)/(33,25)/(20,25)/(40,13)/(22,25)/"+
+		"(26,16)/(24,7)/(31,25)/(40,8)/(7,19)/(58,25)/(58,8)/(41,25)/(28,25)/(40,11)/"+
+		"(14,25)/(34,16)/(58,7)/(7,20)/(51,7)/(40,10)/(23,25)/(13,7)/(49,25)/(40,9)/(36,25)"))
+}
+
+func TestCellSetFromCellsString(t *testing.T) {
+	got := cellSetFromCellsString(t, "(9,7)/(0, 2)/(3 ,2)/(5,3)")
+	want := NewCellSet()
+	want.Add(Cell{0, 2})
+	want.Add(Cell{3, 2})
+	want.Add(Cell{5, 3})
+	want.Add(Cell{9, 7})
+	checkCellSet(t, got, want)
+}
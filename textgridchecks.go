@@ -130,7 +130,8 @@ func (t *TextGrid) FollowCell(c Cell, blocked *Cell) *CellSet {
 	case t.IsCrossOnLine(c):
 		return t.followCrossOnLine(c, blocked)
 	}
-	panic("Cannot follow cell: cannot determine cell type")
+	failf(c, "ambiguous input: cannot determine the type of cell %q", t.GetCell(c))
+	return nil
 }
 
 func (t *TextGrid) followIntersection(c Cell, blocked *Cell) *CellSet {
@@ -254,14 +255,148 @@ func (t *TextGrid) CellContainsDashedLineChar(c Cell) bool {
 }
 
 func (t *TextGrid) IsArrowhead(c Cell) bool {
-	return t.IsNorthArrowhead(c) || t.IsSouthArrowhead(c) || t.IsWestArrowhead(c) || t.IsEastArrowhead(c)
+	_, ok := t.findArrowhead(c)
+	return ok
+}
+
+// IsDiagonalArrowhead and IsDiagonalLine only find the characters put by
+// ReplaceDiagonalLines.
+func (t *TextGrid) IsDiagonalArrowhead(c Cell) bool {
+	return isOneOf(t.GetCell(c), text_diagonalArrowheads)
+}
+func (t *TextGrid) IsDiagonalLine(c Cell) bool { return isOneOf(t.GetCell(c), text_diagonalLines) }
+
+func (t *TextGrid) IsNorthArrowhead(c Cell) bool { return t.isArrowheadPointing(c, Cell{0, -1}) }
+func (t *TextGrid) IsWestArrowhead(c Cell) bool  { return t.isArrowheadPointing(c, Cell{-1, 0}) }
+func (t *TextGrid) IsEastArrowhead(c Cell) bool  { return t.isArrowheadPointing(c, Cell{1, 0}) }
+func (t *TextGrid) IsSouthArrowhead(c Cell) bool { return t.isArrowheadPointing(c, Cell{0, 1}) }
+
+func (t *TextGrid) isArrowheadPointing(c, dir Cell) bool {
+	a, ok := t.findArrowhead(c)
+	return ok && a.Dir == dir
+}
+
+// ArrowheadTail returns the cell from which a line should enter the
+// arrowhead at c, i.e. the neighbour opposite to where it points.
+func (t *TextGrid) ArrowheadTail(c Cell) Cell {
+	if a, ok := t.findArrowhead(c); ok {
+		return a.Tail
+	}
+	return c
 }
 
-func (t *TextGrid) IsNorthArrowhead(c Cell) bool { return t.GetCell(c) == '^' }
-func (t *TextGrid) IsWestArrowhead(c Cell) bool  { return t.GetCell(c) == '<' }
-func (t *TextGrid) IsEastArrowhead(c Cell) bool  { return t.GetCell(c) == '>' }
-func (t *TextGrid) IsSouthArrowhead(c Cell) bool {
-	return isOneOf(t.GetCell(c), "Vv") && t.IsVerticalLine(c.North())
+/*
+arrowhead is the ending of a line found by findArrowhead. Besides the
+plain arrowheads '^', 'v', '<' and '>' (and the diagonal ones put by
+ReplaceDiagonalLines), drawn in the style chosen for the whole diagram,
+a line can end in:
+
+	---o      a circle
+	---#      a diamond, also drawn as ◆:
+	---◆
+	---◇      a hollow diamond, also drawn as <> on horizontal lines:
+	---<>
+	---<|     a crow's foot, i.e. an arrowhead turned back to the line,
+	          touching the side of a shape
+
+The 'o' and '#' must not touch any letters or digits, so that they
+aren't a part of a word. Without a shape to touch, '^', '<' and '>' are
+plain arrowheads, and a 'v' not below a line is text.
+*/
+type arrowhead struct {
+	Dir   Cell // where it points, away from the line
+	Tail  Cell // from which the line enters it
+	Style graphical.ArrowheadStyle
+	Plain bool // drawn in the style chosen for the whole diagram
+	Cells int  // its length along Dir; 0 for the second cell of <>
+}
+
+func (t *TextGrid) findArrowhead(c Cell) (arrowhead, bool) {
+	at := func(dx, dy int) Cell { return Cell{c.X + dx, c.Y + dy} }
+	plain := func(dir Cell) (arrowhead, bool) {
+		return arrowhead{Dir: dir, Tail: Cell{c.X - dir.X, c.Y - dir.Y}, Plain: true, Cells: 1}, true
+	}
+	ending := func(dir Cell, style graphical.ArrowheadStyle) (arrowhead, bool) {
+		return arrowhead{Dir: dir, Tail: Cell{c.X - dir.X, c.Y - dir.Y}, Style: style, Cells: 1}, true
+	}
+	ch := t.GetCell(c)
+	switch ch {
+	case '<', '>':
+		dir, other := Cell{-1, 0}, '>'
+		if ch == '>' {
+			dir, other = Cell{1, 0}, '<'
+		}
+		back, ahead, beyond := at(-dir.X, 0), at(dir.X, 0), at(-2*dir.X, 0)
+		if t.GetCell(back) == other {
+			switch {
+			case t.IsHorizontalLine(ahead) && (ch == '<' || !t.IsHorizontalLine(beyond)):
+				// the cell of <> next to the line
+				a, _ := ending(Cell{-dir.X, 0}, graphical.ARROWHEAD_HOLLOW_DIAMOND)
+				a.Cells = 2
+				return a, true
+			case t.IsHorizontalLine(beyond):
+				return arrowhead{Dir: dir, Tail: back, Style: graphical.ARROWHEAD_HOLLOW_DIAMOND}, true
+			}
+		}
+		if t.IsHorizontalLine(ahead) && !t.IsHorizontalLine(back) && t.IsBoundary(back) {
+			return ending(Cell{-dir.X, 0}, graphical.ARROWHEAD_CROWS_FOOT)
+		}
+		return plain(dir)
+	case '^':
+		if t.IsVerticalLine(c.North()) && !t.IsVerticalLine(c.South()) && t.IsBoundary(c.South()) {
+			return ending(Cell{0, 1}, graphical.ARROWHEAD_CROWS_FOOT)
+		}
+		return plain(Cell{0, -1})
+	case 'v', 'V':
+		switch {
+		case t.IsVerticalLine(c.North()):
+			return plain(Cell{0, 1})
+		case t.IsVerticalLine(c.South()) && t.IsBoundary(c.North()) && !isAlphNum(t.GetCell(c.West())) && !isAlphNum(t.GetCell(c.East())):
+			return ending(Cell{0, -1}, graphical.ARROWHEAD_CROWS_FOOT)
+		}
+	case 'o', '#', '◆', '◇':
+		style := graphical.ARROWHEAD_CIRCLE
+		switch ch {
+		case '#', '◆':
+			style = graphical.ARROWHEAD_DIAMOND
+		case '◇':
+			style = graphical.ARROWHEAD_HOLLOW_DIAMOND
+		}
+		if dir, ok := t.endingDirection(c); ok {
+			return ending(dir, style)
+		}
+	}
+	for dir, arrow := range diagonalArrowheads {
+		if ch == arrow {
+			return plain(dir)
+		}
+	}
+	return arrowhead{}, false
+}
+
+// endingDirection returns the direction away from the line which ends at
+// c, for the endings drawn with the same character whichever way they
+// point. An 'o' or '#' must not touch letters or digits.
+func (t *TextGrid) endingDirection(c Cell) (Cell, bool) {
+	word := func(dx, dy int) bool { return isAlphNum(t.GetCell(Cell{c.X + dx, c.Y + dy})) }
+	if ch := t.GetCell(c); ch == 'o' || ch == '#' {
+		if t.IsBullet(c.X, c.Y) || word(-1, 0) || word(1, 0) || word(0, -1) || word(0, 1) {
+			return Cell{}, false
+		}
+	}
+	for _, dir := range []Cell{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+		tail := Cell{c.X - dir.X, c.Y - dir.Y}
+		if dir.Y == 0 && t.IsHorizontalLine(tail) || dir.X == 0 && t.IsVerticalLine(tail) {
+			return dir, true
+		}
+	}
+	for dir := range diagonalArrowheads {
+		tail := Cell{c.X - dir.X, c.Y - dir.Y}
+		if step, ok := t.diagonalStep(tail, text_diagonalLines); ok && (step == dir || step == Cell{-dir.X, -dir.Y}) {
+			return dir, true
+		}
+	}
+	return Cell{}, false
 }
 
 func (t *TextGrid) IsPointCell(c Cell) bool {
@@ -278,6 +413,8 @@ const (
 	text_horizontalLines        = `-=`
 	text_verticalLines          = `|:`
 	text_arrowHeads             = `<>^vV`
+	text_diagonalLines          = `╱╲`
+	text_diagonalArrowheads     = `↖↗↘↙`
 	text_cornerChars            = `\/+`
 	text_pointMarkers           = `*`
 	text_dashedLines            = `:~=`
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/akavel/ditaa/fontmeasure"
+	"github.com/akavel/ditaa/graphical"
+)
+
+// shapeTypeTags maps the types of shapes to the markup tags setting them.
+var shapeTypeTags = map[graphical.ShapeType]string{}
+
+func init() {
+	for tag, typ := range markupTags {
+		shapeTypeTags[typ] = tag
+	}
+}
+
+/*
+RenderText draws the diagram back as ASCII art, the reverse of NewDiagram:
+
+ 1. The points of every shape are snapped to the cells of the diagram's
+    Grid, and its sides drawn between them with '-' and '|' ('=' and
+    ':' if dashed), or '/' and '\' if diagonal. Corners become '+', or
+    '/' and '\' if round. Where lines join, '+' is drawn, or '*' at the
+    end of a diagonal; where they cross without a joint, the vertical
+    one is kept, as in -|-.
+ 2. Arrowheads and point markers are drawn over the lines.
+ 3. Labels are put in the cells they were laid out from, undoing the
+    alignment done by NewDiagram.
+ 4. Fill colors and shape types are written as color codes and markup
+    tags in the first free space inside their shapes.
+ 5. The colors of the lines other than the foreground color of the theme
+    are written as color codes in the first free space next to them, and
+    those of the labels outside shapes before them.
+
+The color codes are named after the palette of the theme, which should be
+the one the diagram was interpreted with; nil means the default theme.
+
+The resulting grid has the same coordinates as the grids created by
+TextGrid.LoadFrom, i.e. including the blank border.
+*/
+func RenderText(d *graphical.Diagram, theme *graphical.Theme) (*TextGrid, error) {
+	if theme == nil {
+		theme = graphical.DefaultTheme()
+	}
+	gg := d.Grid
+	if gg.CellW <= 0 || gg.CellH <= 0 || gg.W < 0 || gg.H < 0 {
+		return nil, fmt.Errorf("cannot render a grid of %dx%d pixels with cells of %dx%d to text", gg.W, gg.H, gg.CellW, gg.CellH)
+	}
+	w := (gg.W + gg.CellW - 1) / gg.CellW
+	h := (gg.H + gg.CellH - 1) / gg.CellH
+	r := textRenderer{grid: NewTextGrid(w, h), roundCorners: map[Cell]bool{}, openEnds: map[Cell]bool{}}
+
+	extras := []*graphical.Shape{}
+	for i := range d.Shapes {
+		shape := &d.Shapes[i]
+		switch shape.Type {
+		case graphical.TYPE_ARROWHEAD, graphical.TYPE_POINT_MARKER:
+			extras = append(extras, shape)
+			continue
+		}
+		r.drawShape(shape, gg)
+	}
+	// a line ending at a round corner of another one is taken for ending
+	// on a '+' by NewDiagram
+	for _, c := range r.lockedEnds {
+		if !r.roundCorners[c] {
+			r.merge(c, '+')
+		}
+	}
+	for _, shape := range extras {
+		if len(shape.Points) == 0 {
+			continue
+		}
+		if shape.Type == graphical.TYPE_ARROWHEAD {
+			r.drawArrowhead(shape, gg)
+			continue
+		}
+		bb := graphical.Bounds(shape.Points)
+		center := graphical.Point{X: (bb.Min.X + bb.Max.X) / 2, Y: (bb.Min.Y + bb.Max.Y) / 2}
+		r.set(Cell(gg.CellFor(center)), '*')
+	}
+
+	r.font = *fontmeasure.GetFontForHeight(baseFont, gg.CellH)
+	r.lefts, r.rights = map[int]int{}, map[int]int{}
+	for _, label := range d.Labels {
+		r.lefts[label.X]++
+		r.rights[label.X+r.width(label)]++
+	}
+	starts := make([]Cell, len(d.Labels))
+	for i, label := range d.Labels {
+		starts[i] = r.drawLabel(label, gg)
+	}
+
+	for i := range d.Shapes {
+		shape := &d.Shapes[i]
+		if !shape.Closed || shape.Type == graphical.TYPE_ARROWHEAD {
+			continue
+		}
+		if shape.FillColor != nil {
+			for _, code := range colorCodes(*shape.FillColor, theme) {
+				if r.placeInside(code, shape, d) {
+					break
+				}
+			}
+		}
+		if tag, ok := shapeTypeTags[shape.Type]; ok {
+			r.placeInside("{"+tag+"}", shape, d)
+		}
+	}
+
+	for i := range d.Shapes {
+		shape := &d.Shapes[i]
+		if shape.Closed || shape.Type == graphical.TYPE_POINT_MARKER || shape.StrokeColor == theme.Foreground {
+			continue
+		}
+		for _, code := range colorCodes(shape.StrokeColor, theme) {
+			if r.placeBeside(code, i, d) {
+				break
+			}
+		}
+	}
+	for i, label := range d.Labels {
+		if label.Color == theme.Foreground || label.Color == theme.InverseText {
+			continue
+		}
+		for _, code := range colorCodes(label.Color, theme) {
+			if r.placeBefore(code, starts[i], d) {
+				break
+			}
+		}
+	}
+	return r.grid, nil
+}
+
+// WriteText writes the diagram as ASCII art (see RenderText), without
+// the blank border and trailing whitespace.
+func WriteText(d *graphical.Diagram, w io.Writer, theme *graphical.Theme) error {
+	grid, err := RenderText(d, theme)
+	if err != nil {
+		return err
+	}
+	rows := []string{}
+	indent := blankBorderSize
+	for _, row := range grid.Rows {
+		s := strings.TrimRight(string(row), " ")
+		if s != "" {
+			if n := len(row) - len([]rune(strings.TrimLeft(string(row), " "))); n < indent {
+				indent = n
+			}
+		}
+		rows = append(rows, s)
+	}
+	for len(rows) > 0 && rows[len(rows)-1] == "" {
+		rows = rows[:len(rows)-1]
+	}
+	skip := 0
+	for skip < blankBorderSize && skip < len(rows) && rows[skip] == "" {
+		skip++
+	}
+	bw := bufio.NewWriter(w)
+	for _, s := range rows[skip:] {
+		if s != "" {
+			s = string([]rune(s)[indent:])
+		}
+		fmt.Fprintln(bw, s)
+	}
+	return bw.Flush()
+}
+
+type textRenderer struct {
+	grid *TextGrid
+	// roundCorners are the cells of the round corners drawn, openEnds
+	// those of the ends of lines not on a '+', and lockedEnds those of the
+	// ends of lines on a '+', which are drawn last
+	roundCorners map[Cell]bool
+	openEnds     map[Cell]bool
+	lockedEnds   []Cell
+	font         fontmeasure.Font
+	// lefts and rights count the labels starting and ending at each X
+	lefts, rights map[int]int
+}
+
+func (r *textRenderer) width(label graphical.Label) int {
+	font := r.font
+	font.Size = label.FontSize
+	return font.WidthFor(label.Text)
+}
+
+// rank tells which character wins if two of them are drawn in a cell.
+func rank(ch rune) int {
+	switch ch {
+	case ' ':
+		return 0
+	case '-', '|', '=', ':':
+		return 1
+	case '/', '\\':
+		return 2
+	}
+	return 3
+}
+
+func isHorizontal(ch rune) bool { return ch == '-' || ch == '=' }
+func isVertical(ch rune) bool   { return ch == '|' || ch == ':' }
+
+func (r *textRenderer) set(c Cell, ch rune) {
+	if !r.grid.IsOutOfBounds(c) {
+		r.grid.SetCell(c, ch)
+	}
+}
+
+// merge draws a part of a boundary in the cell, keeping the more
+// important of the old and new characters.
+func (r *textRenderer) merge(c Cell, ch rune) {
+	if r.grid.IsOutOfBounds(c) {
+		return
+	}
+	old := r.grid.GetCell(c)
+	switch {
+	case isHorizontal(old) && isVertical(ch), isVertical(old) && isHorizontal(ch):
+		r.grid.SetCell(c, '+')
+	case rank(ch) > rank(old):
+		r.grid.SetCell(c, ch)
+	}
+}
+
+func (r *textRenderer) drawShape(shape *graphical.Shape, gg graphical.Grid) {
+	n := len(shape.Points)
+	if n == 0 {
+		return
+	}
+	cells := make([]Cell, n)
+	for i, p := range shape.Points {
+		cells[i] = Cell(gg.CellFor(p))
+	}
+	if !shape.Closed && n > 1 {
+		cells[0] = endCell(shape.Points[0], shape.Points[1], gg)
+		cells[n-1] = endCell(shape.Points[n-1], shape.Points[n-2], gg)
+	}
+	sides := n - 1
+	if shape.Closed {
+		sides = n
+	}
+	for i := 0; i < sides; i++ {
+		j := (i + 1) % n
+		ch := sideChar(shape.Points[i], shape.Points[j], shape.Dashed)
+		if ch == 0 {
+			continue // cannot be drawn in text
+		}
+		r.drawSide(cells[i], cells[j], ch)
+		if !shape.Closed && i == 0 && !shape.Points[i].Locked {
+			r.merge(cells[i], ch)
+			r.openEnds[cells[i]] = true
+		}
+		if !shape.Closed && j == n-1 && !shape.Points[j].Locked {
+			r.merge(cells[j], ch)
+			r.openEnds[cells[j]] = true
+		}
+	}
+	for i, p := range shape.Points {
+		isEnd := !shape.Closed && (i == 0 || i == n-1)
+		// the diagonal side of a point of a line, if any
+		diagonal := rune(0)
+		for _, j := range []int{i - 1, i + 1} {
+			if shape.Closed || j < 0 || j >= n {
+				continue
+			}
+			if ch := sideChar(p, shape.Points[j], false); ch == '/' || ch == '\\' {
+				diagonal = ch
+			}
+		}
+		switch {
+		case isEnd && p.Locked && diagonal != 0:
+			// a '+' would not be taken as a part of the diagonal
+			r.merge(cells[i], '*')
+		case isEnd && p.Locked:
+			r.lockedEnds = append(r.lockedEnds, cells[i])
+		case isEnd:
+		case diagonal != 0:
+			r.merge(cells[i], diagonal)
+		case p.Type == graphical.POINT_ROUND:
+			r.merge(cells[i], roundCornerChar(cells[i], cells[(i+n-1)%n], cells[(i+1)%n]))
+			r.roundCorners[cells[i]] = true
+		default:
+			r.merge(cells[i], '+')
+		}
+	}
+}
+
+// sideChar returns the character for drawing a line from a to b, or 0 if
+// the line is neither horizontal, vertical nor diagonal.
+func sideChar(a, b graphical.Point, dashed bool) rune {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	switch {
+	case dy == 0 && dashed:
+		return '='
+	case dy == 0:
+		return '-'
+	case dx == 0 && dashed:
+		return ':'
+	case dx == 0:
+		return '|'
+	case (dx > 0) == (dy > 0):
+		return '\\'
+	}
+	return '/'
+}
+
+// drawSide draws the cells between a and b, excluding a and b. Where it
+// crosses another side, the vertical one is kept, as in -|-, since there
+// is no joint; where another line ends on it, they are joined with '+'.
+func (r *textRenderer) drawSide(a, b Cell, ch rune) {
+	dx, dy := sign(b.X-a.X), sign(b.Y-a.Y)
+	if (dx != 0 && dy != 0 && abs(b.X-a.X) != abs(b.Y-a.Y)) || a == b {
+		return
+	}
+	for c := (Cell{a.X + dx, a.Y + dy}); c != b; c = (Cell{c.X + dx, c.Y + dy}) {
+		switch old := r.grid.GetCell(c); {
+		case r.openEnds[c]:
+			r.merge(c, ch)
+		case isVertical(old) && isHorizontal(ch):
+		case isHorizontal(old) && isVertical(ch):
+			r.grid.SetCell(c, ch)
+		default:
+			r.merge(c, ch)
+		}
+	}
+}
+
+// roundCornerChar returns '/' for the top-left and bottom-right corners,
+// and '\' for the other ones.
+func roundCornerChar(c, prev, next Cell) rune {
+	east := prev.X > c.X || next.X > c.X
+	south := prev.Y > c.Y || next.Y > c.Y
+	if east == south {
+		return '/'
+	}
+	return '\\'
+}
+
+// arrowheadChar returns the arrow pointing from the middle of the base of
+// the arrowhead to its tip.
+func arrowheadChar(points []graphical.Point) rune {
+	tip, b1, b2, ok := graphical.ArrowheadTip(points)
+	if !ok {
+		return '>'
+	}
+	return arrowChar(arrowheadDir(tip, b1, b2))
+}
+
+func arrowheadDir(tip, b1, b2 graphical.Point) Cell {
+	dx, dy := tip.X-(b1.X+b2.X)/2, tip.Y-(b1.Y+b2.Y)/2
+	switch {
+	case math.Abs(dx) > 2*math.Abs(dy):
+		return Cell{compare(dx, 0), 0}
+	case math.Abs(dy) > 2*math.Abs(dx):
+		return Cell{0, compare(dy, 0)}
+	}
+	return Cell{compare(dx, 0), compare(dy, 0)}
+}
+
+func arrowChar(dir Cell) rune {
+	switch dir {
+	case Cell{0, -1}:
+		return '^'
+	case Cell{0, 1}:
+		return 'v'
+	case Cell{-1, 0}:
+		return '<'
+	case Cell{1, 0}:
+		return '>'
+	}
+	return diagonalArrowheads[dir]
+}
+
+// drawArrowhead puts the character of the arrowhead in the cell in the
+// middle of its triangle, turning it back to the line for a crow's foot,
+// or <> in the two cells of a long hollow diamond.
+func (r *textRenderer) drawArrowhead(shape *graphical.Shape, gg graphical.Grid) {
+	tip, b1, b2, ok := graphical.ArrowheadTip(shape.Points)
+	if !ok {
+		bb := graphical.Bounds(shape.Points)
+		center := graphical.Point{X: (bb.Min.X + bb.Max.X) / 2, Y: (bb.Min.Y + bb.Max.Y) / 2}
+		r.set(Cell(gg.CellFor(center)), '>')
+		return
+	}
+	dir := arrowheadDir(tip, b1, b2)
+	mid := graphical.Point{X: (b1.X + b2.X) / 2, Y: (b1.Y + b2.Y) / 2}
+	along := func(f float64) Cell {
+		return Cell(gg.CellFor(graphical.Point{X: mid.X + f*(tip.X-mid.X), Y: mid.Y + f*(tip.Y-mid.Y)}))
+	}
+	c := along(0.5)
+	switch shape.Arrowhead {
+	case graphical.ARROWHEAD_CIRCLE:
+		r.set(c, 'o')
+	case graphical.ARROWHEAD_DIAMOND:
+		r.set(c, '#')
+	case graphical.ARROWHEAD_HOLLOW_DIAMOND:
+		if dir.Y == 0 && math.Abs(tip.X-mid.X) > 1.5*float64(gg.CellW) {
+			r.set(along(0.25), arrowChar(Cell{-dir.X, 0}))
+			r.set(along(0.75), arrowChar(dir))
+			break
+		}
+		r.set(c, '◇')
+	case graphical.ARROWHEAD_CROWS_FOOT:
+		if dir.X == 0 || dir.Y == 0 {
+			dir = Cell{-dir.X, -dir.Y}
+		}
+		r.set(c, arrowChar(dir))
+	default:
+		r.set(c, arrowChar(dir))
+	}
+}
+
+// drawLabel writes the label in the cells NewDiagram would make it from,
+// and returns the first one.
+func (r *textRenderer) drawLabel(label graphical.Label, gg graphical.Grid) Cell {
+	text := []rune(label.Text)
+	if len(text) == 0 {
+		return Cell{-1, -1}
+	}
+	width := r.width(label)
+	n := len(text)
+
+	// find the column for which NewDiagram would put the label where it
+	// is; labels are only aligned to a side if there are others in the
+	// same column, otherwise they are centered
+	centered := func(col int) int {
+		minX, maxX := col*gg.CellW, (col+n)*gg.CellW
+		return minX + abs((maxX-minX)/2-width/2)
+	}
+	col := int(math.Floor((float64(label.X)+float64(width)/2)/float64(gg.CellW) - float64(n)/2 + 0.5))
+	switch {
+	case r.lefts[label.X] > 1 && label.X%gg.CellW == 0:
+		col = label.X / gg.CellW
+	case r.rights[label.X+width] > 1 && (label.X+width)%gg.CellW == 0:
+		col = (label.X+width)/gg.CellW - n
+	case centered(col) == label.X:
+	case centered(col-1) == label.X:
+		col--
+	case centered(col+1) == label.X:
+		col++
+	case label.X%gg.CellW == 0:
+		col = label.X / gg.CellW
+	case (label.X+width)%gg.CellW == 0:
+		col = (label.X+width)/gg.CellW - n
+	}
+	row := gg.CellFor(graphical.Point{X: 0, Y: float64(label.Y - 1)}).Y
+
+	if text[0] == '•' {
+		// see TextGrid.replaceBullets
+		text = append([]rune("* "), text[1:]...)
+		col--
+	}
+	for i, ch := range text {
+		if ch != ' ' {
+			r.set(Cell{col + i, row}, ch)
+		}
+	}
+	return Cell{col, row}
+}
+
+// placeInside writes s in the first free space inside the shape,
+// preferably where it doesn't stick to other text. It returns false if
+// there is no room for s.
+func (r *textRenderer) placeInside(s string, shape *graphical.Shape, d *graphical.Diagram) bool {
+	gg := d.Grid
+	bb := graphical.Bounds(shape.Points)
+	min, max := Cell(gg.CellFor(bb.Min)), Cell(gg.CellFor(bb.Max))
+	n := len([]rune(s))
+	inside := func(c Cell) bool {
+		p := graphical.Point{X: gg.CellMidX(graphical.Cell(c)), Y: gg.CellMidY(graphical.Cell(c))}
+		return FindSmallestShapeContaining(p, d.Shapes) == shape
+	}
+	fits := func(x, y int, padded bool) bool {
+		if padded && (isAlphNum(r.grid.Get(x-1, y)) || isAlphNum(r.grid.Get(x+n, y))) {
+			return false
+		}
+		for i := 0; i < n; i++ {
+			c := Cell{x + i, y}
+			if !r.grid.IsBlank(c) || !inside(c) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, padded := range []bool{true, false} {
+		for y := min.Y + 1; y < max.Y; y++ {
+			for x := min.X + 1; x+n <= max.X; x++ {
+				if fits(x, y, padded) {
+					r.grid.WriteStringTo(Cell{x, y}, s)
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// placeBeside writes the color code s in the first free space next to the
+// line d.Shapes[i], where NewDiagram would not take it for the color of
+// another line. It returns false if there is no such space.
+func (r *textRenderer) placeBeside(s string, i int, d *graphical.Diagram) bool {
+	gg := d.Grid
+	line := &d.Shapes[i]
+	n := len(line.Points)
+	if n < 2 {
+		return false
+	}
+	cells := make([]Cell, n)
+	for k, p := range line.Points {
+		cells[k] = Cell(gg.CellFor(p))
+	}
+	cells[0] = endCell(line.Points[0], line.Points[1], gg)
+	cells[n-1] = endCell(line.Points[n-1], line.Points[n-2], gg)
+
+	size := len([]rune(s))
+	fits := func(x, y int, padded bool) bool {
+		for k := -1; k <= size; k++ {
+			ch := r.grid.Get(x+k, y)
+			if k >= 0 && k < size && ch != ' ' || isAlphNum(ch) || padded && ch != ' ' {
+				return false
+			}
+		}
+		for _, j := range findLinesBeside(CellColorPair{Cell: Cell{x, y}, Code: s}, d.Shapes, gg) {
+			if d.Shapes[j].StrokeColor != line.StrokeColor {
+				return false
+			}
+		}
+		return true
+	}
+	for _, padded := range []bool{true, false} {
+		for k := 1; k < n; k++ {
+			a, b := cells[k-1], cells[k]
+			dx, dy := sign(b.X-a.X), sign(b.Y-a.Y)
+			for c := a; ; c = (Cell{c.X + dx, c.Y + dy}) {
+				var tries []Cell
+				if dy == 0 {
+					tries = []Cell{{c.X, c.Y - 1}, {c.X, c.Y + 1}}
+				} else {
+					tries = []Cell{{c.X + 1, c.Y}, {c.X - size, c.Y}}
+				}
+				for _, t := range tries {
+					if fits(t.X, t.Y, padded) {
+						r.grid.WriteStringTo(t, s)
+						return true
+					}
+				}
+				if c == b || dx == 0 && dy == 0 {
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// placeBefore writes the color code s and a space before the label
+// starting at the cell, if they fit outside any shape and away from the
+// lines.
+func (r *textRenderer) placeBefore(s string, start Cell, d *graphical.Diagram) bool {
+	gg := d.Grid
+	size := len([]rune(s))
+	x := start.X - size - 1
+	if x < 1 || isAlphNum(r.grid.Get(x-1, start.Y)) {
+		return false
+	}
+	for k := 0; k <= size; k++ {
+		c := Cell{x + k, start.Y}
+		p := graphical.Point{X: gg.CellMidX(graphical.Cell(c)), Y: gg.CellMidY(graphical.Cell(c))}
+		if !r.grid.IsBlank(c) || FindSmallestShapeContaining(p, d.Shapes) != nil {
+			return false
+		}
+	}
+	if len(findLinesBeside(CellColorPair{Cell: Cell{x, start.Y}, Code: s}, d.Shapes, gg)) > 0 {
+		return false
+	}
+	r.grid.WriteStringTo(Cell{x, start.Y}, s)
+	return true
+}
+
+// colorCodes returns the color codes for the color, from the exact one to
+// the shortest one, which is rounded to what a 3-digit code can express.
+func colorCodes(c graphical.Color, theme *graphical.Theme) []string {
+	if name, ok := theme.NameOf(c); ok {
+		return []string{"c" + name}
+	}
+	r, g, b := (int(c.R)+8)/17, (int(c.G)+8)/17, (int(c.B)+8)/17
+	rounded := graphical.Color{R: uint8(r * 17), G: uint8(g * 17), B: uint8(b * 17), A: 255}
+	short := fmt.Sprintf("c%X%X%X", r, g, b)
+	if name, ok := theme.NameOf(rounded); ok {
+		short = "c" + name
+	}
+	switch {
+	case c == rounded:
+		return []string{short}
+	case c.A == 255:
+		return []string{fmt.Sprintf("c#%02X%02X%02X", c.R, c.G, c.B), short}
+	}
+	return []string{fmt.Sprintf("c#%02X%02X%02X%02X", c.R, c.G, c.B, c.A), short}
+}
+
+func sign(x int) int {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	}
+	return 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/akavel/ditaa/graphical"
+)
+
+// normalShapes describes the shapes of a diagram independently of their
+// order and of the order and starting point of their points, which vary
+// between runs of NewDiagram.
+func normalShapes(d *graphical.Diagram) []string {
+	result := []string{}
+	for _, s := range d.Shapes {
+		points := []string{}
+		for _, p := range s.Points {
+			points = append(points, fmt.Sprintf("%g,%g,%d", p.X, p.Y, p.Type))
+		}
+		sort.Strings(points)
+		fill := "none"
+		if s.FillColor != nil {
+			fill = s.FillColor.Hex()
+		}
+		result = append(result, fmt.Sprintf("%s closed=%v dashed=%v fill=%s stroke=%s arrowhead=%s %v",
+			s.Type, s.Closed, s.Dashed, fill, s.StrokeColor.Hex(), s.Arrowhead, points))
+	}
+	sort.Strings(result)
+	return result
+}
+
+// normalLabels describes the labels of a diagram in the order of their
+// positions.
+func normalLabels(d *graphical.Diagram) []string {
+	result := []string{}
+	for _, l := range d.Labels {
+		result = append(result, fmt.Sprintf("%d,%d %q size=%g color=%s onLine=%v outline=%v",
+			l.Y, l.X, l.Text, l.FontSize, l.Color.Hex(), l.OnLine, l.Outline))
+	}
+	sort.Strings(result)
+	return result
+}
+
+// diffLines returns the lines of got missing in want and the other way
+// round, marked with + and -.
+func diffLines(got, want []string) []string {
+	count := map[string]int{}
+	for _, s := range got {
+		count[s]++
+	}
+	for _, s := range want {
+		count[s]--
+	}
+	diff := []string{}
+	for s, n := range count {
+		for ; n > 0; n-- {
+			diff = append(diff, "+ "+s)
+		}
+		for ; n < 0; n++ {
+			diff = append(diff, "- "+s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func TestRenderTextRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(testTexts, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		d, err := NewDiagram(loadTestGrid(t, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		var buf strings.Builder
+		err = WriteText(&d.G, &buf, nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		grid := NewTextGrid(0, 0)
+		grid.LoadFrom(strings.NewReader(buf.String()))
+		again, err := NewDiagram(grid)
+		if err != nil {
+			t.Errorf("%s: re-parsing: %v in:\n%s", name, err, buf.String())
+			continue
+		}
+		diff := diffLines(normalShapes(&again.G), normalShapes(&d.G))
+		diff = append(diff, diffLines(normalLabels(&again.G), normalLabels(&d.G))...)
+		if len(diff) > 0 {
+			t.Errorf("%s: re-parsed diagram differs:\n%s\nin:\n%s", name, strings.Join(diff, "\n"), buf.String())
+		}
+	}
+}
+
+func TestRenderTextInvalidGrid(t *testing.T) {
+	grids := []graphical.Grid{
+		{W: 100, H: 140, CellW: 0, CellH: 14},
+		{W: 100, H: 140, CellW: 10, CellH: 0},
+		{W: 100, H: 140, CellW: -10, CellH: 14},
+		{W: -100, H: 140, CellW: 10, CellH: 14},
+	}
+	for _, g := range grids {
+		_, err := RenderText(&graphical.Diagram{Grid: g}, nil)
+		if err == nil {
+			t.Errorf("%+v: got no error", g)
+		}
+	}
+}